@@ -0,0 +1,269 @@
+package microbot
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type AccessLogFormat string
+
+const (
+	AccessLogJSON   AccessLogFormat = "json"
+	AccessLogLogfmt AccessLogFormat = "logfmt"
+)
+
+// Fields which can be emitted in access logs.
+const (
+	FieldTime      = "time"
+	FieldMethod    = "method"
+	FieldRoute     = "route"
+	FieldURI       = "uri"
+	FieldStatus    = "status"
+	FieldDuration  = "duration_ms"
+	FieldIP        = "ip"
+	FieldSize      = "size"
+	FieldUserAgent = "user_agent"
+	FieldReferer   = "referer"
+)
+
+const redacted = "[REDACTED]"
+
+type (
+	// AccessLogConfig defines the config for access logs of Middleware.
+	AccessLogConfig struct {
+		// Format of the lines written to Output.
+		// Optional. Default value "json".
+		Format AccessLogFormat `yaml:"format"`
+
+		// Output receives the access log lines.
+		// Optional. Default value os.Stdout.
+		Output io.Writer `yaml:"-"`
+
+		// Logger receives access logs as slog records instead of Output.
+		// Optional. Default value nil.
+		Logger *slog.Logger `yaml:"-"`
+
+		// Fields emitted for each request, in order.
+		// Optional. Default value DefaultAccessLogConfig.Fields.
+		Fields []string `yaml:"fields"`
+
+		// Headers are request headers emitted as "header.<name>" fields.
+		// Optional. Default value nil.
+		Headers []string `yaml:"headers"`
+
+		// SampleRate is the fraction of successful requests which are logged,
+		// requests with status 400 or above are always logged. As 0 is the
+		// default, a negative value logs only those and slow requests.
+		// Optional. Default value 1.
+		SampleRate float64 `yaml:"sample_rate"`
+
+		// SlowThreshold makes requests taking at least this long always logged.
+		// Optional. Default value 0, which disables it.
+		SlowThreshold time.Duration `yaml:"slow_threshold"`
+
+		// RedactHeaders are headers whose values are replaced in logs.
+		// Optional. Default value Authorization, Cookie and Set-Cookie.
+		RedactHeaders []string `yaml:"redact_headers"`
+
+		// RedactQuery are query parameters whose values are replaced in logs.
+		// Optional. Default value nil.
+		RedactQuery []string `yaml:"redact_query"`
+	}
+
+	accessLogger struct {
+		config        AccessLogConfig
+		redactHeaders map[string]bool
+		redactQuery   map[string]bool
+		mu            sync.Mutex
+	}
+
+	accessLogField struct {
+		key   string
+		value interface{}
+	}
+)
+
+var (
+	// DefaultAccessLogConfig is the default access log config.
+	DefaultAccessLogConfig = AccessLogConfig{
+		Format: AccessLogJSON,
+		Fields: []string{
+			FieldTime, FieldMethod, FieldRoute, FieldURI, FieldStatus,
+			FieldDuration, FieldIP, FieldSize, FieldUserAgent,
+		},
+		SampleRate:    1,
+		RedactHeaders: []string{"Authorization", "Cookie", "Set-Cookie"},
+	}
+)
+
+func newAccessLogger(config *AccessLogConfig) *accessLogger {
+	if config == nil {
+		return nil
+	}
+	l := &accessLogger{
+		config:        *config,
+		redactHeaders: make(map[string]bool),
+		redactQuery:   make(map[string]bool),
+	}
+	// Defaults
+	if l.config.Format == "" {
+		l.config.Format = DefaultAccessLogConfig.Format
+	}
+	if l.config.Output == nil {
+		l.config.Output = os.Stdout
+	}
+	if len(l.config.Fields) == 0 {
+		l.config.Fields = DefaultAccessLogConfig.Fields
+	}
+	if l.config.SampleRate == 0 {
+		l.config.SampleRate = DefaultAccessLogConfig.SampleRate
+	}
+	if l.config.RedactHeaders == nil {
+		l.config.RedactHeaders = DefaultAccessLogConfig.RedactHeaders
+	}
+	for _, h := range l.config.RedactHeaders {
+		l.redactHeaders[http.CanonicalHeaderKey(h)] = true
+	}
+	for _, q := range l.config.RedactQuery {
+		l.redactQuery[q] = true
+	}
+	return l
+}
+
+func (l *accessLogger) log(r *http.Request, ip, route string, status, size int, d time.Duration) {
+	if status < http.StatusBadRequest &&
+		(l.config.SlowThreshold == 0 || d < l.config.SlowThreshold) &&
+		l.config.SampleRate < 1 && rand.Float64() >= l.config.SampleRate {
+		return
+	}
+
+	var fields []accessLogField
+	for _, f := range l.config.Fields {
+		var v interface{}
+		switch f {
+		case FieldTime:
+			v = time.Now().Format(time.RFC3339Nano)
+		case FieldMethod:
+			v = r.Method
+		case FieldRoute:
+			v = route
+		case FieldURI:
+			v = l.uri(r)
+		case FieldStatus:
+			v = status
+		case FieldDuration:
+			v = float64(d.Nanoseconds()) / float64(time.Millisecond)
+		case FieldIP:
+			v = ip
+		case FieldSize:
+			v = size
+		case FieldUserAgent:
+			v = r.UserAgent()
+		case FieldReferer:
+			v = r.Referer()
+		default:
+			continue
+		}
+		fields = append(fields, accessLogField{f, v})
+	}
+	for _, h := range l.config.Headers {
+		v := r.Header.Get(h)
+		if v != "" && l.redactHeaders[http.CanonicalHeaderKey(h)] {
+			v = redacted
+		}
+		fields = append(fields, accessLogField{"header." + strings.ToLower(h), v})
+	}
+
+	if l.config.Logger != nil {
+		level := slog.LevelInfo
+		switch {
+		case status >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case status >= http.StatusBadRequest,
+			l.config.SlowThreshold != 0 && d >= l.config.SlowThreshold:
+			level = slog.LevelWarn
+		}
+		attrs := make([]slog.Attr, 0, len(fields))
+		for _, f := range fields {
+			attrs = append(attrs, slog.Any(f.key, f.value))
+		}
+		l.config.Logger.LogAttrs(r.Context(), level, "http request", attrs...)
+		return
+	}
+
+	var buf bytes.Buffer
+	if l.config.Format == AccessLogLogfmt {
+		for i, f := range fields {
+			if i > 0 {
+				buf.WriteByte(' ')
+			}
+			buf.WriteString(f.key)
+			buf.WriteByte('=')
+			buf.WriteString(logfmtValue(f.value))
+		}
+	} else {
+		buf.WriteByte('{')
+		for i, f := range fields {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			k, _ := json.Marshal(f.key)
+			v, err := json.Marshal(f.value)
+			if err != nil {
+				v, _ = json.Marshal(err.Error())
+			}
+			buf.Write(k)
+			buf.WriteByte(':')
+			buf.Write(v)
+		}
+		buf.WriteByte('}')
+	}
+	buf.WriteByte('\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.config.Output.Write(buf.Bytes())
+}
+
+func (l *accessLogger) uri(r *http.Request) string {
+	if len(l.redactQuery) == 0 || r.URL.RawQuery == "" {
+		return r.RequestURI
+	}
+	q := r.URL.Query()
+	for k, vs := range q {
+		if l.redactQuery[k] {
+			for i := range vs {
+				vs[i] = redacted
+			}
+		}
+	}
+	return r.URL.Path + "?" + q.Encode()
+}
+
+func logfmtValue(v interface{}) string {
+	var s string
+	switch v := v.(type) {
+	case string:
+		s = v
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		b, _ := json.Marshal(v)
+		s = string(b)
+	}
+	if s == "" || strings.ContainsAny(s, " =\"\t\r\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
@@ -0,0 +1,101 @@
+package microbot
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// accessLogged serves a request behind a middleware writing access logs with
+// config, and returns the lines written.
+func accessLogged(t *testing.T, config AccessLogConfig, r *http.Request) []string {
+	t.Helper()
+	var out bytes.Buffer
+	config.Output = &out
+	m := http.NewServeMux()
+	m.HandleFunc("/items/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	m.HandleFunc("/fail", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	m.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+	})
+	route := func(r *http.Request) string { return r.URL.Path }
+	MiddlewareWithConfig(route, MiddlewareConfig{AccessLog: &config})(m).ServeHTTP(httptest.NewRecorder(), r)
+	return strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+}
+
+func TestAccessLogLogfmt(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/items/1?token=secret&page=2", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	r.Header.Set("X-Request-Id", "abc")
+	r.Header.Set("User-Agent", "test agent")
+	lines := accessLogged(t, AccessLogConfig{
+		Format:      AccessLogLogfmt,
+		Fields:      []string{FieldMethod, FieldURI, FieldStatus, FieldSize, FieldUserAgent},
+		Headers:     []string{"Authorization", "X-Request-Id"},
+		RedactQuery: []string{"token"},
+	}, r)
+
+	want := `method=GET uri="/items/1?page=2&token=%5BREDACTED%5D" status=200 size=2 ` +
+		`user_agent="test agent" header.authorization=[REDACTED] header.x-request-id=abc`
+	if len(lines) != 1 || lines[0] != want {
+		t.Errorf("logged %q, want %q", lines, want)
+	}
+}
+
+func TestAccessLogJSON(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/items/1", nil)
+	r.Header.Set("Cookie", "session=secret")
+	lines := accessLogged(t, AccessLogConfig{Headers: []string{"Cookie"}}, r)
+	if len(lines) != 1 {
+		t.Fatalf("logged %d lines, want 1", len(lines))
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &fields); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range DefaultAccessLogConfig.Fields {
+		if _, ok := fields[f]; !ok {
+			t.Errorf("no field %s in %s", f, lines[0])
+		}
+	}
+	if fields[FieldStatus] != float64(200) || fields[FieldURI] != "/items/1" {
+		t.Errorf("logged %s", lines[0])
+	}
+	// Cookie is redacted by default.
+	if fields["header.cookie"] != redacted {
+		t.Errorf("logged cookie %v, want it redacted", fields["header.cookie"])
+	}
+}
+
+func TestAccessLogSampling(t *testing.T) {
+	tests := []struct {
+		name       string
+		sampleRate float64
+		path       string
+		logged     bool
+	}{
+		{"default logs all", 0, "/items/1", true},
+		{"negative skips successes", -1, "/items/1", false},
+		{"negative logs errors", -1, "/fail", true},
+		{"negative logs slow requests", -1, "/slow", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := accessLogged(t, AccessLogConfig{
+				SampleRate:    tt.sampleRate,
+				SlowThreshold: 10 * time.Millisecond,
+			}, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if logged := lines[0] != ""; logged != tt.logged {
+				t.Errorf("logged = %v, want %v", logged, tt.logged)
+			}
+		})
+	}
+}
@@ -0,0 +1,357 @@
+package microbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Results of the alerts of key events.
+const (
+	alertSent        = "sent"
+	alertFailed      = "failed"
+	alertDropped     = "dropped"
+	alertRateLimited = "rate_limited"
+	alertDuplicate   = "duplicate"
+)
+
+type (
+	// AlertSink notifies of a key event, see NewWebhookSink, NewSlackSink
+	// and NewSMTPSink.
+	AlertSink interface {
+		Send(ctx context.Context, e KeyEvent) error
+	}
+
+	// AlertRule sends the key events it matches to a sink.
+	AlertRule struct {
+		// Name of the rule in logs and metrics.
+		// Optional. Default value "rule-" followed by the index of the rule.
+		Name string `yaml:"name"`
+
+		// Sink the matched events are sent to.
+		// Required.
+		Sink AlertSink `yaml:"-"`
+
+		// Types of the matched events.
+		// Optional. Default value nil, which matches every type.
+		Types []string `yaml:"types"`
+
+		// MinSeverity of the matched events.
+		// Optional. Default value SeverityInfo.
+		MinSeverity Severity `yaml:"min_severity"`
+
+		// Attributes the matched events must have, with the same values.
+		// Optional. Default value nil.
+		Attributes map[string]string `yaml:"attributes"`
+
+		// RateLimit is the number of alerts sent per RateInterval, further
+		// alerts are dropped.
+		// Optional. Default value 0, which is no limit.
+		RateLimit int `yaml:"rate_limit"`
+
+		// RateInterval of RateLimit.
+		// Optional. Default value 1 minute.
+		RateInterval time.Duration `yaml:"rate_interval"`
+
+		// DedupWindow drops the events of the same type and content as an
+		// event alerted within the window.
+		// Optional. Default value 0, which is no deduplication.
+		DedupWindow time.Duration `yaml:"dedup_window"`
+
+		// MaxRetries of a failed send.
+		// Optional. Default value 3.
+		MaxRetries int `yaml:"max_retries"`
+
+		// Backoff before the first retry, doubled on each further retry.
+		// Optional. Default value 1 second.
+		Backoff time.Duration `yaml:"backoff"`
+
+		// Timeout of each send.
+		// Optional. Default value 10 seconds.
+		Timeout time.Duration `yaml:"timeout"`
+	}
+
+	// AlerterConfig defines the config for Alerter.
+	AlerterConfig struct {
+		// Rules matched against each key event, which may match several.
+		// Required.
+		Rules []AlertRule `yaml:"rules"`
+
+		// QueueSize is the number of alerts waiting to be sent, further
+		// alerts are dropped so that recording events never blocks.
+		// Optional. Default value 100.
+		QueueSize int `yaml:"queue_size"`
+	}
+
+	// Alerter sends key events to sinks according to rules, from a
+	// background goroutine.
+	Alerter struct {
+		rules []*alertRule
+		// mu guards queue against sends after Close.
+		mu     sync.RWMutex
+		queue  chan alert
+		closed bool
+		done   chan struct{}
+	}
+
+	alertRule struct {
+		AlertRule
+		mu          sync.Mutex
+		windowStart time.Time
+		windowCount int
+		// sent is the time each type and content was last alerted.
+		sent map[string]time.Time
+	}
+
+	alert struct {
+		rule  *alertRule
+		event KeyEvent
+	}
+
+	// alertError is a send failure which is not worth retrying.
+	alertError struct {
+		err error
+	}
+)
+
+var (
+	// DefaultAlertRule holds the default values of AlertRule.
+	DefaultAlertRule = AlertRule{
+		RateInterval: time.Minute,
+		MaxRetries:   3,
+		Backoff:      time.Second,
+		Timeout:      10 * time.Second,
+	}
+
+	// DefaultAlerterConfig is the default Alerter config.
+	DefaultAlerterConfig = AlerterConfig{
+		QueueSize: 100,
+	}
+
+	alertersMu sync.RWMutex
+	alerters   []*Alerter
+)
+
+func (e alertError) Error() string { return e.err.Error() }
+
+// EnableAlerts starts alerting on the key events recorded from now on. The
+// Alerter is closed by Shutdown.
+func EnableAlerts(config AlerterConfig) (*Alerter, error) {
+	a, err := NewAlerter(config)
+	if err != nil {
+		return nil, err
+	}
+	alertersMu.Lock()
+	alerters = append(alerters, a)
+	alertersMu.Unlock()
+	startKeyEvents()
+	return a, nil
+}
+
+func NewAlerter(config AlerterConfig) (*Alerter, error) {
+	if len(config.Rules) == 0 {
+		return nil, errors.New("microbot: Alerter Rules are required")
+	}
+	// Defaults
+	if config.QueueSize == 0 {
+		config.QueueSize = DefaultAlerterConfig.QueueSize
+	}
+	a := &Alerter{
+		queue: make(chan alert, config.QueueSize),
+		done:  make(chan struct{}),
+	}
+	for i, rule := range config.Rules {
+		if rule.Sink == nil {
+			return nil, fmt.Errorf("microbot: Sink of alert rule %d is required", i)
+		}
+		if rule.Name == "" {
+			rule.Name = "rule-" + strconv.Itoa(i)
+		}
+		if rule.RateInterval == 0 {
+			rule.RateInterval = DefaultAlertRule.RateInterval
+		}
+		if rule.MaxRetries == 0 {
+			rule.MaxRetries = DefaultAlertRule.MaxRetries
+		}
+		if rule.Backoff == 0 {
+			rule.Backoff = DefaultAlertRule.Backoff
+		}
+		if rule.Timeout == 0 {
+			rule.Timeout = DefaultAlertRule.Timeout
+		}
+		a.rules = append(a.rules, &alertRule{AlertRule: rule, sent: make(map[string]time.Time)})
+	}
+	go a.run()
+	return a, nil
+}
+
+// Notify queues the alerts of e, without blocking. Events are ignored once
+// the Alerter is closed.
+func (a *Alerter) Notify(e KeyEvent) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		return
+	}
+	now := time.Now()
+	for _, rule := range a.rules {
+		if !rule.match(e) {
+			continue
+		}
+		if result := rule.queue(a.queue, e, now); result != "" {
+			keyEventAlerts.WithLabelValues(rule.Name, result).Inc()
+		}
+	}
+}
+
+// Close stops queuing alerts and waits for the queued ones to be sent.
+func (a *Alerter) Close() error {
+	alertersMu.Lock()
+	for i, other := range alerters {
+		if other == a {
+			alerters = append(alerters[:i], alerters[i+1:]...)
+			break
+		}
+	}
+	alertersMu.Unlock()
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.queue)
+	}
+	a.mu.Unlock()
+	<-a.done
+	return nil
+}
+
+func (a *Alerter) run() {
+	defer close(a.done)
+	for al := range a.queue {
+		result := alertSent
+		if err := al.rule.send(al.event); err != nil {
+			result = alertFailed
+			slog.Warn("microbot: key event alert failed",
+				"rule", al.rule.Name, "type", al.event.Type, "seq", al.event.Seq, "error", err)
+		}
+		keyEventAlerts.WithLabelValues(al.rule.Name, result).Inc()
+	}
+}
+
+func (r *alertRule) match(e KeyEvent) bool {
+	if e.Severity < r.MinSeverity {
+		return false
+	}
+	if len(r.Types) > 0 {
+		found := false
+		for _, t := range r.Types {
+			if t == e.Type {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for k, v := range r.Attributes {
+		if e.Attributes[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// queue applies the deduplication and the rate limit, and queues the alert
+// of e without blocking. It returns the result of a dropped alert, or "" if
+// the alert is queued. Only queued alerts count as sent for the deduplication
+// and the rate limit, so that a dropped alert does not hold back the next.
+func (r *alertRule) queue(queue chan<- alert, e KeyEvent, now time.Time) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := e.Type + "\x00" + e.Content
+	if r.DedupWindow > 0 {
+		for k, t := range r.sent {
+			if now.Sub(t) >= r.DedupWindow {
+				delete(r.sent, k)
+			}
+		}
+		if _, ok := r.sent[key]; ok {
+			return alertDuplicate
+		}
+	}
+	if r.RateLimit > 0 {
+		if now.Sub(r.windowStart) >= r.RateInterval {
+			r.windowStart = now
+			r.windowCount = 0
+		}
+		if r.windowCount >= r.RateLimit {
+			return alertRateLimited
+		}
+	}
+	select {
+	case queue <- alert{r, e}:
+	default:
+		return alertDropped
+	}
+	if r.DedupWindow > 0 {
+		r.sent[key] = now
+	}
+	r.windowCount++
+	return ""
+}
+
+// send sends e to the sink, retrying with backoff on failure.
+func (r *alertRule) send(e KeyEvent) error {
+	var err error
+	backoff := r.Backoff
+	for i := 0; i <= r.MaxRetries; i++ {
+		if i > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), r.Timeout)
+		err = r.Sink.Send(ctx, e)
+		cancel()
+		if _, ok := err.(alertError); err == nil || ok {
+			return err
+		}
+	}
+	return err
+}
+
+// notifyAlerters passes e to the enabled alerters.
+func notifyAlerters(e KeyEvent) {
+	alertersMu.RLock()
+	defer alertersMu.RUnlock()
+	for _, a := range alerters {
+		a.Notify(e)
+	}
+}
+
+// closeAlerters closes the enabled alerters, waiting for their queued alerts
+// until ctx is done.
+func closeAlerters(ctx context.Context) error {
+	alertersMu.RLock()
+	enabled := append([]*Alerter(nil), alerters...)
+	alertersMu.RUnlock()
+	done := make(chan error, 1)
+	go func() {
+		var errs []error
+		for _, a := range enabled {
+			if err := a.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		done <- errors.Join(errs...)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,308 @@
+package microbot
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const signatureHeader = "X-Microbot-Signature"
+
+type (
+	// WebhookConfig defines the config for WebhookSink.
+	WebhookConfig struct {
+		// URL the events are posted to.
+		// Required.
+		URL string `yaml:"url"`
+
+		// Secret signs the body with HMAC-SHA256, sent in the
+		// X-Microbot-Signature header as "sha256=" followed by the hex digest.
+		// Optional. Default value "", which sends no signature.
+		Secret string `yaml:"secret"`
+
+		// Headers added to each request.
+		// Optional. Default value nil.
+		Headers map[string]string `yaml:"headers"`
+
+		// Client sends the requests.
+		// Optional. Default value http.DefaultClient.
+		Client *http.Client `yaml:"-"`
+	}
+
+	// WebhookSink posts each event as JSON.
+	WebhookSink struct {
+		config WebhookConfig
+	}
+
+	// SlackConfig defines the config for SlackSink.
+	SlackConfig struct {
+		// WebhookURL of a Slack incoming webhook, or of a compatible service.
+		// Required.
+		WebhookURL string `yaml:"webhook_url"`
+
+		// Channel overrides the channel of the webhook.
+		// Optional. Default value "".
+		Channel string `yaml:"channel"`
+
+		// Username overrides the name of the webhook.
+		// Optional. Default value "".
+		Username string `yaml:"username"`
+
+		// Client sends the requests.
+		// Optional. Default value http.DefaultClient.
+		Client *http.Client `yaml:"-"`
+	}
+
+	// SlackSink posts each event as a Slack message.
+	SlackSink struct {
+		config SlackConfig
+	}
+
+	// SMTPConfig defines the config for SMTPSink.
+	SMTPConfig struct {
+		// Addr of the server, "host:port".
+		// Required.
+		Addr string `yaml:"addr"`
+
+		// Username and Password authenticate with PLAIN, if Username is set.
+		// Optional. Default value "".
+		Username string `yaml:"username"`
+		Password string `yaml:"password"`
+
+		// From address of the mails.
+		// Required.
+		From string `yaml:"from"`
+
+		// To addresses of the mails.
+		// Required.
+		To []string `yaml:"to"`
+
+		// SubjectPrefix of the mails.
+		// Optional. Default value "[microbot] ".
+		SubjectPrefix string `yaml:"subject_prefix"`
+	}
+
+	// SMTPSink mails each event.
+	SMTPSink struct {
+		config SMTPConfig
+		auth   smtp.Auth
+	}
+)
+
+var (
+	// DefaultSMTPConfig is the default SMTPSink config.
+	DefaultSMTPConfig = SMTPConfig{
+		SubjectPrefix: "[microbot] ",
+	}
+)
+
+func NewWebhookSink(config WebhookConfig) (*WebhookSink, error) {
+	if config.URL == "" {
+		return nil, errors.New("microbot: Webhook URL is required")
+	}
+	// Defaults
+	if config.Client == nil {
+		config.Client = http.DefaultClient
+	}
+	return &WebhookSink{config: config}, nil
+}
+
+func (s *WebhookSink) Send(ctx context.Context, e KeyEvent) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return alertError{err}
+	}
+	header := http.Header{}
+	for k, v := range s.config.Headers {
+		header.Set(k, v)
+	}
+	if s.config.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(s.config.Secret))
+		mac.Write(body)
+		header.Set(signatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	return postAlert(ctx, s.config.Client, s.config.URL, header, body)
+}
+
+func NewSlackSink(config SlackConfig) (*SlackSink, error) {
+	if config.WebhookURL == "" {
+		return nil, errors.New("microbot: Slack WebhookURL is required")
+	}
+	// Defaults
+	if config.Client == nil {
+		config.Client = http.DefaultClient
+	}
+	return &SlackSink{config: config}, nil
+}
+
+func (s *SlackSink) Send(ctx context.Context, e KeyEvent) error {
+	msg := map[string]string{"text": "*" + alertSubject(e) + "*\n" + alertBody(e)}
+	if s.config.Channel != "" {
+		msg["channel"] = s.config.Channel
+	}
+	if s.config.Username != "" {
+		msg["username"] = s.config.Username
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return alertError{err}
+	}
+	return postAlert(ctx, s.config.Client, s.config.WebhookURL, nil, body)
+}
+
+func NewSMTPSink(config SMTPConfig) (*SMTPSink, error) {
+	if config.Addr == "" {
+		return nil, errors.New("microbot: SMTP Addr is required")
+	}
+	if config.From == "" || len(config.To) == 0 {
+		return nil, errors.New("microbot: SMTP From and To are required")
+	}
+	// Defaults
+	if config.SubjectPrefix == "" {
+		config.SubjectPrefix = DefaultSMTPConfig.SubjectPrefix
+	}
+	s := &SMTPSink{config: config}
+	if config.Username != "" {
+		host, _, err := net.SplitHostPort(config.Addr)
+		if err != nil {
+			return nil, err
+		}
+		s.auth = smtp.PlainAuth("", config.Username, config.Password, host)
+	}
+	return s, nil
+}
+
+// Send mails e. The connection is dialed with ctx, and closed when ctx ends.
+func (s *SMTPSink) Send(ctx context.Context, e KeyEvent) error {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", s.config.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(s.config.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s%s\r\n", s.config.SubjectPrefix, strings.NewReplacer("\r", " ", "\n", " ").Replace(alertSubject(e)))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(alertBody(e), "\n", "\r\n"))
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", s.config.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	host, _, err := net.SplitHostPort(s.config.Addr)
+	if err != nil {
+		return alertError{err}
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if s.auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return alertError{errors.New("microbot: SMTP server does not support AUTH")}
+		}
+		if err := c.Auth(s.auth); err != nil {
+			return alertError{err}
+		}
+	}
+	if err := c.Mail(s.config.From); err != nil {
+		return err
+	}
+	for _, to := range s.config.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg.Bytes()); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// postAlert posts a JSON body. Client errors other than rate limiting are
+// not retried.
+func postAlert(ctx context.Context, client *http.Client, url string, header http.Header, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return alertError{err}
+	}
+	req = req.WithContext(ctx)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		err = fmt.Errorf("microbot: alert failed with status %d", resp.StatusCode)
+		if resp.StatusCode/100 == 4 && resp.StatusCode != http.StatusTooManyRequests {
+			return alertError{err}
+		}
+		return err
+	}
+	return nil
+}
+
+func alertSubject(e KeyEvent) string {
+	return fmt.Sprintf("[%s] %s", e.Severity, e.Type)
+}
+
+// alertBody formats the content and the details of e, one per line.
+func alertBody(e KeyEvent) string {
+	var b strings.Builder
+	b.WriteString(e.Content)
+	b.WriteString("\n\n")
+	fmt.Fprintf(&b, "time: %s\n", e.Time.Format(time.RFC3339))
+	keys := make([]string, 0, len(e.Attributes))
+	for k := range e.Attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s: %s\n", k, e.Attributes[k])
+	}
+	if e.Source != "" {
+		fmt.Fprintf(&b, "source: %s\n", e.Source)
+	}
+	if e.RequestID != "" {
+		fmt.Fprintf(&b, "request_id: %s\n", e.RequestID)
+	}
+	if e.TraceID != "" {
+		fmt.Fprintf(&b, "trace_id: %s\n", e.TraceID)
+	}
+	return b.String()
+}
@@ -0,0 +1,299 @@
+package microbot
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var testEvent = KeyEvent{
+	Seq:        7,
+	Type:       "deploy",
+	Content:    "version 2",
+	Time:       time.Unix(1700000000, 0),
+	Severity:   SeverityError,
+	Attributes: map[string]string{"env": "prod"},
+}
+
+func TestWebhookSink(t *testing.T) {
+	var got KeyEvent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write(body)
+		if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); r.Header.Get(signatureHeader) != want {
+			t.Errorf("signature = %q, want %q", r.Header.Get(signatureHeader), want)
+		}
+		if r.Header.Get("X-Team") != "ops" {
+			t.Error("header X-Team is missing")
+		}
+		json.Unmarshal(body, &got)
+	}))
+	defer srv.Close()
+
+	s, err := NewWebhookSink(WebhookConfig{URL: srv.URL, Secret: "secret", Headers: map[string]string{"X-Team": "ops"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Send(context.Background(), testEvent); err != nil {
+		t.Fatal(err)
+	}
+	if got.Seq != testEvent.Seq || got.Content != testEvent.Content || got.Attributes["env"] != "prod" {
+		t.Errorf("posted %+v", got)
+	}
+}
+
+func TestWebhookSinkStatus(t *testing.T) {
+	status := http.StatusBadRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+	s, _ := NewWebhookSink(WebhookConfig{URL: srv.URL})
+
+	var ae alertError
+	if err := s.Send(context.Background(), testEvent); !errors.As(err, &ae) {
+		t.Errorf("status 400 = %v, want a permanent error", err)
+	}
+	status = http.StatusServiceUnavailable
+	if err := s.Send(context.Background(), testEvent); err == nil || errors.As(err, &ae) {
+		t.Errorf("status 503 = %v, want a retryable error", err)
+	}
+}
+
+func TestSlackSink(t *testing.T) {
+	var got map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer srv.Close()
+
+	s, _ := NewSlackSink(SlackConfig{WebhookURL: srv.URL, Channel: "#alerts"})
+	if err := s.Send(context.Background(), testEvent); err != nil {
+		t.Fatal(err)
+	}
+	if got["channel"] != "#alerts" || !strings.HasPrefix(got["text"], "*[error] deploy*\nversion 2") {
+		t.Errorf("posted %v", got)
+	}
+}
+
+// smtpServer is a local SMTP stand-in which accepts one mail per
+// connection and passes its data to mails.
+func smtpServer(t *testing.T, mails chan<- string) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				reply := func(s string) { io.WriteString(conn, s+"\r\n") }
+				reply("220 localhost")
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					switch cmd := strings.ToUpper(strings.Fields(line)[0]); cmd {
+					case "EHLO", "HELO", "MAIL", "RCPT":
+						reply("250 OK")
+					case "DATA":
+						reply("354 go on")
+						var data strings.Builder
+						for {
+							l, err := r.ReadString('\n')
+							if err != nil || l == ".\r\n" {
+								break
+							}
+							data.WriteString(l)
+						}
+						mails <- data.String()
+						reply("250 OK")
+					case "QUIT":
+						reply("221 bye")
+						return
+					default:
+						reply("502 unsupported")
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestSMTPSink(t *testing.T) {
+	mails := make(chan string, 1)
+	s, err := NewSMTPSink(SMTPConfig{Addr: smtpServer(t, mails), From: "bot@example.com", To: []string{"ops@example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Send(context.Background(), testEvent); err != nil {
+		t.Fatal(err)
+	}
+	mail := <-mails
+	for _, want := range []string{"Subject: [microbot] [error] deploy\r\n", "To: ops@example.com\r\n", "version 2\r\n", "env: prod\r\n"} {
+		if !strings.Contains(mail, want) {
+			t.Errorf("mail lacks %q:\n%s", want, mail)
+		}
+	}
+}
+
+func TestSMTPSinkTimeout(t *testing.T) {
+	// The server accepts connections but never greets.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	s, _ := NewSMTPSink(SMTPConfig{Addr: ln.Addr().String(), From: "bot@example.com", To: []string{"ops@example.com"}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	begun := time.Now()
+	if err := s.Send(ctx, testEvent); err == nil {
+		t.Error("Send succeeded without a server")
+	}
+	if d := time.Since(begun); d > 5*time.Second {
+		t.Errorf("Send returned after %v, ctx ended after 100ms", d)
+	}
+}
+
+// recordingSink keeps the events sent, after failing as many sends as
+// failures.
+type recordingSink struct {
+	mu       sync.Mutex
+	events   []KeyEvent
+	failures int
+}
+
+func (s *recordingSink) Send(ctx context.Context, e KeyEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("unavailable")
+	}
+	s.events = append(s.events, e)
+	return nil
+}
+
+func (s *recordingSink) contents() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var c []string
+	for _, e := range s.events {
+		c = append(c, e.Content)
+	}
+	return c
+}
+
+func TestAlerter(t *testing.T) {
+	sink := &recordingSink{failures: 1}
+	a, err := NewAlerter(AlerterConfig{Rules: []AlertRule{{
+		Name:        "test-alerter",
+		Sink:        sink,
+		Types:       []string{"deploy"},
+		MinSeverity: SeverityWarning,
+		RateLimit:   2,
+		DedupWindow: time.Hour,
+		Backoff:     time.Millisecond,
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := func(result string) float64 {
+		return testutil.ToFloat64(keyEventAlerts.WithLabelValues("test-alerter", result))
+	}
+	before := map[string]float64{}
+	for _, result := range []string{alertSent, alertDuplicate, alertRateLimited} {
+		before[result] = counts(result)
+	}
+	for _, e := range []KeyEvent{
+		{Type: "deploy", Content: "1", Severity: SeverityError},
+		{Type: "deploy", Content: "1", Severity: SeverityError},  // duplicate
+		{Type: "deploy", Content: "2", Severity: SeverityInfo},   // below MinSeverity
+		{Type: "restart", Content: "3", Severity: SeverityError}, // other type
+		{Type: "deploy", Content: "4", Severity: SeverityWarning},
+		{Type: "deploy", Content: "5", Severity: SeverityWarning}, // rate limited
+	} {
+		a.Notify(e)
+	}
+	a.Close()
+
+	if got := strings.Join(sink.contents(), ","); got != "1,4" {
+		t.Errorf("sent %s, want 1,4", got)
+	}
+	for result, want := range map[string]float64{alertSent: 2, alertDuplicate: 1, alertRateLimited: 1} {
+		if n := counts(result) - before[result]; n != want {
+			t.Errorf("%s = %v, want %v", result, n, want)
+		}
+	}
+	a.Notify(KeyEvent{Type: "deploy", Content: "6", Severity: SeverityError})
+	if n := len(sink.contents()); n != 2 {
+		t.Errorf("closed Alerter sent %d events", n)
+	}
+}
+
+func TestAlertRuleQueueFull(t *testing.T) {
+	r := &alertRule{
+		AlertRule: AlertRule{DedupWindow: time.Hour, RateLimit: 1, RateInterval: time.Hour},
+		sent:      make(map[string]time.Time),
+	}
+	e := KeyEvent{Type: "deploy", Content: "1"}
+	now := time.Now()
+	// Nothing receives from a full queue.
+	if result := r.queue(make(chan alert), e, now); result != alertDropped {
+		t.Fatalf("result = %q, want %s", result, alertDropped)
+	}
+	// The dropped alert is neither a duplicate nor counted by the rate limit.
+	queue := make(chan alert, 2)
+	if result := r.queue(queue, e, now); result != "" {
+		t.Errorf("result after a dropped alert = %q, want it queued", result)
+	}
+	if result := r.queue(queue, e, now); result != alertDuplicate {
+		t.Errorf("result of a queued alert again = %q, want %s", result, alertDuplicate)
+	}
+	if result := r.queue(queue, KeyEvent{Type: "deploy", Content: "2"}, now); result != alertRateLimited {
+		t.Errorf("result beyond the rate limit = %q, want %s", result, alertRateLimited)
+	}
+	if len(queue) != 1 {
+		t.Errorf("queued %d alerts, want 1", len(queue))
+	}
+}
+
+func TestEnableAlerts(t *testing.T) {
+	resetKeyEvents(t)
+	sink := &recordingSink{}
+	a, err := EnableAlerts(AlerterConfig{Rules: []AlertRule{{Sink: sink, Types: []string{"alerted"}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	KeyEventList{}.New("alerted", "1")
+	KeyEventList{}.New("ignored", "2")
+	a.Close()
+	if got := sink.contents(); len(got) != 1 || got[0] != "1" {
+		t.Errorf("sent %v, want [1]", got)
+	}
+}
@@ -0,0 +1,198 @@
+package microbot
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// grpcMethod is the HTTP method of every gRPC call, used to report panics
+// alongside the ones of http handlers.
+const grpcMethod = "POST"
+
+func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return UnaryServerInterceptorWithConfig(DefaultMiddlewareConfig)
+}
+
+// UnaryServerInterceptorWithConfig returns a unary server interceptor which
+// records metrics of calls and recovers from panics in handlers. Only the
+// stack and logger settings of config are used.
+func UnaryServerInterceptorWithConfig(config MiddlewareConfig) grpc.UnaryServerInterceptor {
+	if config.StackSize == 0 {
+		config.StackSize = DefaultMiddlewareConfig.StackSize
+	}
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		service, method := splitGRPCMethod(info.FullMethod)
+		begun := time.Now()
+		grpcServerReceived.WithLabelValues(service, method).Inc()
+		defer func() {
+			if err == nil {
+				grpcServerSent.WithLabelValues(service, method).Inc()
+			}
+			observeGRPC(grpcServerHandled, grpcServerDuration, service, method, err, begun)
+		}()
+
+		defer func() {
+			if v := recover(); v != nil {
+				err = recoverGRPCPanic(ctx, config, v, info.FullMethod)
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return StreamServerInterceptorWithConfig(DefaultMiddlewareConfig)
+}
+
+// StreamServerInterceptorWithConfig returns a stream server interceptor which
+// records metrics of calls and messages, and recovers from panics in handlers.
+// Only the stack and logger settings of config are used.
+func StreamServerInterceptorWithConfig(config MiddlewareConfig) grpc.StreamServerInterceptor {
+	if config.StackSize == 0 {
+		config.StackSize = DefaultMiddlewareConfig.StackSize
+	}
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		service, method := splitGRPCMethod(info.FullMethod)
+		begun := time.Now()
+		defer func() {
+			observeGRPC(grpcServerHandled, grpcServerDuration, service, method, err, begun)
+		}()
+
+		defer func() {
+			if v := recover(); v != nil {
+				err = recoverGRPCPanic(ss.Context(), config, v, info.FullMethod)
+			}
+		}()
+		return handler(srv, &serverStream{ServerStream: ss, service: service, method: method})
+	}
+}
+
+// UnaryClientInterceptor returns a unary client interceptor which records
+// metrics of calls.
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, fullMethod string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		service, method := splitGRPCMethod(fullMethod)
+		begun := time.Now()
+		grpcClientSent.WithLabelValues(service, method).Inc()
+		err := invoker(ctx, fullMethod, req, reply, cc, opts...)
+		if err == nil {
+			grpcClientReceived.WithLabelValues(service, method).Inc()
+		}
+		observeGRPC(grpcClientHandled, grpcClientDuration, service, method, err, begun)
+		return err
+	}
+}
+
+// StreamClientInterceptor returns a stream client interceptor which records
+// metrics of calls and messages. A call is finished when the stream returns
+// an error or io.EOF on receive, or on its single response when the server
+// does not stream.
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, fullMethod string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		service, method := splitGRPCMethod(fullMethod)
+		begun := time.Now()
+		cs, err := streamer(ctx, desc, cc, fullMethod, opts...)
+		if err != nil {
+			observeGRPC(grpcClientHandled, grpcClientDuration, service, method, err, begun)
+			return nil, err
+		}
+		return &clientStream{
+			ClientStream:  cs,
+			service:       service,
+			method:        method,
+			serverStreams: desc.ServerStreams,
+			begun:         begun,
+		}, nil
+	}
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	service, method string
+}
+
+func (s *serverStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		grpcServerSent.WithLabelValues(s.service, s.method).Inc()
+	}
+	return err
+}
+
+func (s *serverStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		grpcServerReceived.WithLabelValues(s.service, s.method).Inc()
+	}
+	return err
+}
+
+type clientStream struct {
+	grpc.ClientStream
+	service, method string
+	serverStreams   bool
+	begun           time.Time
+	// finished guards against finishing twice, SendMsg and RecvMsg may run
+	// on different goroutines.
+	finished sync.Once
+}
+
+func (s *clientStream) SendMsg(m interface{}) error {
+	err := s.ClientStream.SendMsg(m)
+	if err == nil {
+		grpcClientSent.WithLabelValues(s.service, s.method).Inc()
+	} else {
+		s.finish(err)
+	}
+	return err
+}
+
+func (s *clientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	switch err {
+	case nil:
+		grpcClientReceived.WithLabelValues(s.service, s.method).Inc()
+		if !s.serverStreams {
+			s.finish(nil)
+		}
+	case io.EOF:
+		s.finish(nil)
+	default:
+		s.finish(err)
+	}
+	return err
+}
+
+func (s *clientStream) finish(err error) {
+	s.finished.Do(func() {
+		observeGRPC(grpcClientHandled, grpcClientDuration, s.service, s.method, err, s.begun)
+	})
+}
+
+func observeGRPC(handled *prometheus.CounterVec, handling *prometheus.HistogramVec, service, method string, err error, begun time.Time) {
+	d := time.Since(begun).Nanoseconds() / int64(time.Millisecond)
+	handled.WithLabelValues(service, method, status.Code(err).String()).Inc()
+	handling.WithLabelValues(service, method).Observe(float64(d))
+}
+
+func recoverGRPCPanic(ctx context.Context, config MiddlewareConfig, v interface{}, fullMethod string) error {
+	err, _ := recoverPanic(ctx, config, v, grpcMethod, fullMethod, fullMethod)
+	return status.Errorf(codes.Internal, "%v", err)
+}
+
+// splitGRPCMethod splits "/package.Service/Method" into service and method.
+func splitGRPCMethod(fullMethod string) (string, string) {
+	fullMethod = strings.TrimPrefix(fullMethod, "/")
+	if i := strings.LastIndex(fullMethod, "/"); i >= 0 {
+		return fullMethod[:i], fullMethod[i+1:]
+	}
+	return "unknown", fullMethod
+}
@@ -0,0 +1,238 @@
+package microbot
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const grpcTestService = "microbot.test.Echo"
+
+// grpcTestDesc echoes StringValue messages, failing with the code NotFound
+// on the Fail method.
+var grpcTestDesc = grpc.ServiceDesc{
+	ServiceName: grpcTestService,
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Unary", Handler: grpcTestUnary("Unary", func(in *wrapperspb.StringValue) (*wrapperspb.StringValue, error) {
+			return in, nil
+		})},
+		{MethodName: "Fail", Handler: grpcTestUnary("Fail", func(in *wrapperspb.StringValue) (*wrapperspb.StringValue, error) {
+			return nil, status.Error(codes.NotFound, in.GetValue())
+		})},
+	},
+	Streams: []grpc.StreamDesc{
+		{StreamName: "ClientStream", ClientStreams: true, Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			var joined string
+			for {
+				in := new(wrapperspb.StringValue)
+				err := stream.RecvMsg(in)
+				if err == io.EOF {
+					return stream.SendMsg(wrapperspb.String(joined))
+				}
+				if err != nil {
+					return err
+				}
+				joined += in.GetValue()
+			}
+		}},
+		{StreamName: "ServerStream", ServerStreams: true, Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			in := new(wrapperspb.StringValue)
+			if err := stream.RecvMsg(in); err != nil {
+				return err
+			}
+			for _, c := range in.GetValue() {
+				if err := stream.SendMsg(wrapperspb.String(string(c))); err != nil {
+					return err
+				}
+			}
+			return nil
+		}},
+	},
+}
+
+func grpcTestUnary(method string, fn func(*wrapperspb.StringValue) (*wrapperspb.StringValue, error)) grpc.MethodHandler {
+	return func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		in := new(wrapperspb.StringValue)
+		if err := dec(in); err != nil {
+			return nil, err
+		}
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			return fn(req.(*wrapperspb.StringValue))
+		}
+		return interceptor(ctx, in, &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + grpcTestService + "/" + method}, handler)
+	}
+}
+
+// grpcTestConn serves grpcTestDesc over bufconn, with the interceptors on
+// both sides.
+func grpcTestConn(t *testing.T) *grpc.ClientConn {
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(UnaryServerInterceptor()),
+		grpc.StreamInterceptor(StreamServerInterceptor()))
+	srv.RegisterService(&grpcTestDesc, struct{}{})
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(UnaryClientInterceptor()),
+		grpc.WithStreamInterceptor(StreamClientInterceptor()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// histogramCount returns the number of observations of a histogram.
+func histogramCount(t *testing.T, h *prometheus.HistogramVec, lvs ...string) uint64 {
+	t.Helper()
+	var m dto.Metric
+	if err := h.WithLabelValues(lvs...).(prometheus.Metric).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+// grpcMetrics snapshots the call metrics of a method on both sides.
+type grpcMetrics struct {
+	serverHandled, clientHandled   float64
+	serverDuration, clientDuration uint64
+	serverSent, serverReceived     float64
+	clientSent, clientReceived     float64
+}
+
+func readGRPCMetrics(t *testing.T, method string, code codes.Code) grpcMetrics {
+	return grpcMetrics{
+		serverHandled:  testutil.ToFloat64(grpcServerHandled.WithLabelValues(grpcTestService, method, code.String())),
+		clientHandled:  testutil.ToFloat64(grpcClientHandled.WithLabelValues(grpcTestService, method, code.String())),
+		serverDuration: histogramCount(t, grpcServerDuration, grpcTestService, method),
+		clientDuration: histogramCount(t, grpcClientDuration, grpcTestService, method),
+		serverSent:     testutil.ToFloat64(grpcServerSent.WithLabelValues(grpcTestService, method)),
+		serverReceived: testutil.ToFloat64(grpcServerReceived.WithLabelValues(grpcTestService, method)),
+		clientSent:     testutil.ToFloat64(grpcClientSent.WithLabelValues(grpcTestService, method)),
+		clientReceived: testutil.ToFloat64(grpcClientReceived.WithLabelValues(grpcTestService, method)),
+	}
+}
+
+func (m grpcMetrics) sub(before grpcMetrics) grpcMetrics {
+	return grpcMetrics{
+		serverHandled:  m.serverHandled - before.serverHandled,
+		clientHandled:  m.clientHandled - before.clientHandled,
+		serverDuration: m.serverDuration - before.serverDuration,
+		clientDuration: m.clientDuration - before.clientDuration,
+		serverSent:     m.serverSent - before.serverSent,
+		serverReceived: m.serverReceived - before.serverReceived,
+		clientSent:     m.clientSent - before.clientSent,
+		clientReceived: m.clientReceived - before.clientReceived,
+	}
+}
+
+func TestGRPCInterceptors(t *testing.T) {
+	conn := grpcTestConn(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		method string
+		code   codes.Code
+		call   func(t *testing.T) error
+		want   grpcMetrics
+	}{
+		{"unary", "Unary", codes.OK, func(t *testing.T) error {
+			out := new(wrapperspb.StringValue)
+			if err := conn.Invoke(ctx, "/"+grpcTestService+"/Unary", wrapperspb.String("a"), out); err != nil {
+				return err
+			}
+			if out.GetValue() != "a" {
+				t.Errorf("reply = %q, want a", out.GetValue())
+			}
+			return nil
+		}, grpcMetrics{1, 1, 1, 1, 1, 1, 1, 1}},
+		{"error", "Fail", codes.NotFound, func(t *testing.T) error {
+			err := conn.Invoke(ctx, "/"+grpcTestService+"/Fail", wrapperspb.String("a"), new(wrapperspb.StringValue))
+			if status.Code(err) != codes.NotFound {
+				t.Errorf("err = %v, want NotFound", err)
+			}
+			return nil
+		}, grpcMetrics{1, 1, 1, 1, 0, 1, 1, 0}},
+		{"client stream", "ClientStream", codes.OK, func(t *testing.T) error {
+			cs, err := conn.NewStream(ctx, &grpcTestDesc.Streams[0], "/"+grpcTestService+"/ClientStream")
+			if err != nil {
+				return err
+			}
+			for _, s := range []string{"a", "b", "c"} {
+				if err := cs.SendMsg(wrapperspb.String(s)); err != nil {
+					return err
+				}
+			}
+			// CloseAndRecv, which ends with a successful receive.
+			if err := cs.CloseSend(); err != nil {
+				return err
+			}
+			out := new(wrapperspb.StringValue)
+			if err := cs.RecvMsg(out); err != nil {
+				return err
+			}
+			if out.GetValue() != "abc" {
+				t.Errorf("reply = %q, want abc", out.GetValue())
+			}
+			return nil
+		}, grpcMetrics{1, 1, 1, 1, 1, 3, 3, 1}},
+		{"server stream", "ServerStream", codes.OK, func(t *testing.T) error {
+			cs, err := conn.NewStream(ctx, &grpcTestDesc.Streams[1], "/"+grpcTestService+"/ServerStream")
+			if err != nil {
+				return err
+			}
+			if err := cs.SendMsg(wrapperspb.String("abc")); err != nil {
+				return err
+			}
+			if err := cs.CloseSend(); err != nil {
+				return err
+			}
+			var got string
+			for {
+				out := new(wrapperspb.StringValue)
+				err := cs.RecvMsg(out)
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					return err
+				}
+				got += out.GetValue()
+			}
+			if got != "abc" {
+				t.Errorf("replies = %q, want abc", got)
+			}
+			return nil
+		}, grpcMetrics{1, 1, 1, 1, 3, 1, 1, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := readGRPCMetrics(t, tt.method, tt.code)
+			if err := tt.call(t); err != nil {
+				t.Fatal(err)
+			}
+			if got := readGRPCMetrics(t, tt.method, tt.code).sub(before); got != tt.want {
+				t.Errorf("metrics = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
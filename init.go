@@ -1,17 +1,17 @@
 package microbot
 
 import (
-	"container/list"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
 
 var (
-	duration = prometheus.NewSummaryVec(
-		prometheus.SummaryOpts{
-			Name: "microbot_http_request_duration_milliseconds",
-			Help: "Summary of http request duration in milliseconds.",
+	duration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "microbot_http_request_duration_milliseconds",
+			Help:    "Histogram of http request duration in milliseconds.",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 16),
 		},
 		[]string{"handler", "status", "method", "ip_type"},
 	)
@@ -24,11 +24,148 @@ var (
 		[]string{"handler", "status", "method", "ip_type"},
 	)
 
-	panics = prometheus.NewCounter(
+	inFlightGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "microbot_http_requests_in_flight",
+			Help: "Number of http requests currently served.",
+		})
+
+	httpErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "microbot_http_errors_total",
+			Help: "Total number of errors returned by http handlers.",
+		},
+		[]string{"handler", "status", "type"},
+	)
+
+	ttfb = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "microbot_http_request_ttfb_milliseconds",
+			Help:    "Histogram of time to first byte of http responses in milliseconds.",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 16),
+		},
+		[]string{"handler", "method"},
+	)
+
+	streamedBytes = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "microbot_http_streamed_bytes_total",
+			Help: "Total number of bytes written to streaming http responses.",
+		})
+
+	openStreams = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "microbot_http_open_streams",
+			Help: "Number of streaming http responses currently open.",
+		})
+
+	grpcServerHandled = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "microbot_grpc_server_handled_total",
+			Help: "Total number of gRPC calls completed on the server.",
+		},
+		[]string{"service", "method", "code"},
+	)
+
+	grpcServerDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "microbot_grpc_server_handling_milliseconds",
+			Help:    "Histogram of gRPC call duration on the server in milliseconds.",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 16),
+		},
+		[]string{"service", "method"},
+	)
+
+	grpcServerReceived = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "microbot_grpc_server_msg_received_total",
+			Help: "Total number of gRPC messages received by the server.",
+		},
+		[]string{"service", "method"},
+	)
+
+	grpcServerSent = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "microbot_grpc_server_msg_sent_total",
+			Help: "Total number of gRPC messages sent by the server.",
+		},
+		[]string{"service", "method"},
+	)
+
+	grpcClientHandled = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "microbot_grpc_client_handled_total",
+			Help: "Total number of gRPC calls completed on the client.",
+		},
+		[]string{"service", "method", "code"},
+	)
+
+	grpcClientDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "microbot_grpc_client_handling_milliseconds",
+			Help:    "Histogram of gRPC call duration on the client in milliseconds.",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 16),
+		},
+		[]string{"service", "method"},
+	)
+
+	grpcClientReceived = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "microbot_grpc_client_msg_received_total",
+			Help: "Total number of gRPC messages received by the client.",
+		},
+		[]string{"service", "method"},
+	)
+
+	grpcClientSent = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "microbot_grpc_client_msg_sent_total",
+			Help: "Total number of gRPC messages sent by the client.",
+		},
+		[]string{"service", "method"},
+	)
+
+	clientRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "microbot_http_client_requests_total",
+			Help: "Total number of outgoing http requests which got a response.",
+		},
+		[]string{"host", "route", "method", "status"},
+	)
+
+	clientDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "microbot_http_client_request_duration_milliseconds",
+			Help:    "Histogram of outgoing http request duration in milliseconds.",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 16),
+		},
+		[]string{"host", "route", "method"},
+	)
+
+	clientPhaseDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "microbot_http_client_phase_duration_milliseconds",
+			Help:    "Histogram of DNS, connect and TLS duration of outgoing http requests in milliseconds.",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 16),
+		},
+		[]string{"host", "phase"},
+	)
+
+	clientErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "microbot_http_client_errors_total",
+			Help: "Total number of outgoing http requests which failed.",
+		},
+		[]string{"host", "route", "type"},
+	)
+
+	panics = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "microbot_panic_total",
 			Help: "Total number of panic.",
-		})
+		},
+		[]string{"handler", "fingerprint"},
+	)
 
 	accessibility = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
@@ -37,33 +174,111 @@ var (
 		},
 		[]string{"status"},
 	)
+
+	keyEventsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "microbot_key_events_total",
+			Help: "Total number of key events recorded.",
+		},
+		[]string{"type", "severity"},
+	)
+
+	keyEventsSize = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "microbot_key_events",
+			Help: "Number of key events currently kept in the list.",
+		})
+
+	keyEventsEvicted = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "microbot_key_events_evicted_total",
+			Help: "Total number of key events evicted from the list when it is full, or by retention policies.",
+		},
+		[]string{"type", "reason"},
+	)
+
+	keyEventsDropped = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "microbot_key_events_stream_dropped_total",
+			Help: "Total number of key events dropped for stream subscribers which fell behind.",
+		})
+
+	keyEventAlerts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "microbot_key_event_alerts_total",
+			Help: "Total number of key event alerts by rule and result.",
+		},
+		[]string{"rule", "result"},
+	)
+
+	// collectors are registered in init and unregistered by Shutdown.
+	collectors []prometheus.Collector
+
+	proberStop = make(chan struct{})
+	proberDone = make(chan struct{})
 )
 
 func init() {
-	keyEventList = KeyEventList{
-		data: list.New(),
-		max:  DefaultListMax,
+	processStart = time.Now()
+	collectors = []prometheus.Collector{
+		duration,
+		requests,
+		inFlightGauge,
+		httpErrors,
+		ttfb,
+		streamedBytes,
+		openStreams,
+		clientRequests,
+		clientDuration,
+		clientPhaseDuration,
+		clientErrors,
+		panics,
+		grpcServerHandled,
+		grpcServerDuration,
+		grpcServerReceived,
+		grpcServerSent,
+		grpcClientHandled,
+		grpcClientDuration,
+		grpcClientReceived,
+		grpcClientSent,
+		accessibility,
+		keyEventsTotal,
+		keyEventsSize,
+		keyEventsEvicted,
+		keyEventsDropped,
+		keyEventAlerts,
 	}
+	prometheus.MustRegister(collectors...)
+
+	go probeDB(proberStop, proberDone)
+}
 
-	prometheus.MustRegister(duration)
-	prometheus.MustRegister(requests)
-	prometheus.MustRegister(panics)
-	prometheus.MustRegister(accessibility)
-
-	go func() {
-		ticker := time.NewTicker(30 * time.Second)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-ticker.C:
-				for _, r := range PingDB() {
-					status := "ok"
-					if r.err != nil {
-						status = "error"
-					}
-					accessibility.WithLabelValues(status).Inc()
+// probeDB pings the registered DBs periodically until stop is closed, and
+// records the changes of their reachability as key events.
+func probeDB(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	reachable := make(map[int]bool)
+	for {
+		select {
+		case <-ticker.C:
+			for _, r := range PingDB() {
+				recordDBTransition(reachable, r)
+				eachSink(func(sk sink) {
+					sk.recordDBPing(r.dbType, r.err, time.Duration(r.duration))
+				})
+				if prometheusDisabled.Load() {
+					continue
 				}
+				status := "ok"
+				if r.err != nil {
+					status = "error"
+				}
+				accessibility.WithLabelValues(status).Inc()
 			}
+		case <-stop:
+			return
 		}
-	}()
+	}
 }
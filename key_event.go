@@ -1,71 +1,364 @@
 package microbot
 
 import (
-	"container/list"
+	"context"
+	"log/slog"
 	"net/http"
+	"path/filepath"
+	"runtime"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/pangpanglabs/microbot/utils"
 )
 
-const DefaultListMax = 1000
+const (
+	DefaultListMax = 1000
+
+	// DefaultStoreBuffer is the size of the buffer SetStore enables for
+	// stores other than the in-memory one.
+	DefaultStoreBuffer = 1024
+)
 
 var (
-	lock         sync.RWMutex
-	keyEventList KeyEventList
+	keyEvents = newKeyEventRing(DefaultListMax)
+
+	// storeMu serializes appends, so that events are stored and published in
+	// sequence order.
+	storeMu       sync.Mutex
+	keyEventStore KeyEventStore = keyEvents
+	keyEventSeq   uint64
+
+	// queueLock guards keyEventQueue, which is nil when events are recorded
+	// synchronously, and bufferSet, which is set once SetBuffer is called.
+	queueLock     sync.RWMutex
+	keyEventQueue chan KeyEvent
+	bufferSet     bool
+
+	// pendingMu guards pendingKeyEvents, the number of events queued but not
+	// recorded yet, and keyEventsDrained, which is closed when it drops to 0
+	// and is nil while no drain waits.
+	pendingMu        sync.Mutex
+	pendingKeyEvents int
+	keyEventsDrained chan struct{}
 )
 
 type KeyEvent struct {
-	Type    string
-	Content string
-	Time    time.Time
+	Seq      uint64
+	Type     string
+	Content  string
+	Time     time.Time
+	Severity Severity
+	// Attributes are key/value details of the event.
+	Attributes map[string]string
+	// Source is the file:line which recorded the event.
+	Source string
+	// RequestID and TraceID are set when the event is recorded with the
+	// context of a request served by a middleware.
+	RequestID string
+	TraceID   string
 }
 
-type KeyEventList struct {
-	data *list.List
-	max  int
+// KeyEventOption sets optional fields of a key event.
+type KeyEventOption func(e *KeyEvent)
+
+// KeyEventList is the handle of the key event list of the process.
+type KeyEventList struct{}
+
+// keyEventRing is the in-memory store, which keeps the latest events in a
+// ring buffer.
+type keyEventRing struct {
+	mu     sync.RWMutex
+	events []KeyEvent
+	start  int
+	size   int
 }
 
+func newKeyEventRing(max int) *keyEventRing {
+	return &keyEventRing{events: make([]KeyEvent, max)}
+}
+
+// KeyEventController serves pages of key events, see ParseKeyEventQuery for
+// the query parameters.
 func KeyEventController() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var v []interface{}
-		for iter := keyEventList.data.Back(); iter != nil; iter = iter.Prev() {
-			v = append(v, iter.Value)
+		q, err := ParseKeyEventQuery(r)
+		if err != nil {
+			utils.RenderErrorJson(w, err)
+			return
+		}
+		startKeyEvents()
+		store := currentKeyEventStore()
+		if q.Group == "" {
+			page, err := queryKeyEvents(store, q)
+			utils.Render(w, page, err)
+			return
 		}
-		utils.RenderJson(w, v)
+		events, err := store.Load(q.KeyEventFilter)
+		if err != nil {
+			utils.RenderErrorJson(w, err)
+			return
+		}
+		h, err := q.Histogram(events, time.Now())
+		utils.Render(w, h, err)
 	})
 }
 
+// SetLength sets the capacity of the in-memory store.
 func (KeyEventList) SetLength(max int) {
 	if max < 1 {
 		panic("microbot: invalid queue length")
 	}
-	keyEventList.max = max
+	keyEvents.resize(max)
 }
 
+// SetBuffer makes events recorded by a single background writer, through a
+// channel of the given size which blocks New when full. Size 0 records events
+// synchronously in New, which is the default of the in-memory store, see
+// SetStore for the other stores.
+func (KeyEventList) SetBuffer(size int) {
+	if size < 0 {
+		panic("microbot: invalid buffer size")
+	}
+	queueLock.Lock()
+	defer queueLock.Unlock()
+	bufferSet = true
+	if keyEventQueue != nil {
+		close(keyEventQueue)
+		keyEventQueue = nil
+	}
+	if size > 0 {
+		startKeyEventQueue(size)
+	}
+}
+
+// startKeyEventQueue starts the background writer. queueLock must be held.
+func startKeyEventQueue(size int) {
+	keyEventQueue = make(chan KeyEvent, size)
+	go writeKeyEvents(keyEventQueue)
+}
+
+// New records an event of severity info. It is a shortcut of Record.
 func (KeyEventList) New(t string, c string) {
-	go keyEventList.push(KeyEvent{
-		Type:    t,
-		Content: c,
-		Time:    time.Now(),
+	recordKeyEvent(context.Background(), t, c, nil)
+}
+
+// Record records an event with options.
+func (KeyEventList) Record(t string, c string, opts ...KeyEventOption) {
+	recordKeyEvent(context.Background(), t, c, opts)
+}
+
+// RecordContext records an event with options, taking its request ID and
+// trace ID from ctx.
+func (KeyEventList) RecordContext(ctx context.Context, t string, c string, opts ...KeyEventOption) {
+	recordKeyEvent(ctx, t, c, opts)
+}
+
+func WithSeverity(s Severity) KeyEventOption {
+	return func(e *KeyEvent) {
+		e.Severity = s
+	}
+}
+
+func WithAttribute(key, value string) KeyEventOption {
+	return func(e *KeyEvent) {
+		if e.Attributes == nil {
+			e.Attributes = make(map[string]string)
+		}
+		e.Attributes[key] = value
+	}
+}
+
+func WithAttributes(attrs map[string]string) KeyEventOption {
+	return func(e *KeyEvent) {
+		for k, v := range attrs {
+			WithAttribute(k, v)(e)
+		}
+	}
+}
+
+// recordKeyEvent must be called directly by the exported recording methods,
+// so that the source is their caller.
+func recordKeyEvent(ctx context.Context, t string, c string, opts []KeyEventOption) {
+	startKeyEvents()
+	eachSink(func(sk sink) {
+		sk.recordKeyEvent(t)
 	})
+	e := KeyEvent{
+		Type:     t,
+		Content:  c,
+		Time:     time.Now(),
+		Severity: SeverityInfo,
+	}
+	if _, file, line, ok := runtime.Caller(2); ok {
+		e.Source = filepath.Base(filepath.Dir(file)) + "/" + filepath.Base(file) + ":" + strconv.Itoa(line)
+	}
+	e.RequestID = RequestIDFromContext(ctx)
+	if span := SpanFromContext(ctx); span != nil {
+		e.TraceID = span.SpanContext.TraceID.String()
+	}
+	for _, opt := range opts {
+		opt(&e)
+	}
+	if !prometheusDisabled.Load() {
+		keyEventsTotal.WithLabelValues(e.Type, e.Severity.String()).Inc()
+	}
+
+	queueLock.RLock()
+	defer queueLock.RUnlock()
+	if keyEventQueue == nil {
+		appendKeyEvent(e)
+		return
+	}
+	pendingMu.Lock()
+	pendingKeyEvents++
+	pendingMu.Unlock()
+	keyEventQueue <- e
 }
 
-func (q *KeyEventList) push(v interface{}) {
-	if q.data.Len() >= q.max {
-		q.pop()
+func writeKeyEvents(queue <-chan KeyEvent) {
+	for e := range queue {
+		appendKeyEvent(e)
+		pendingMu.Lock()
+		pendingKeyEvents--
+		if pendingKeyEvents == 0 && keyEventsDrained != nil {
+			close(keyEventsDrained)
+			keyEventsDrained = nil
+		}
+		pendingMu.Unlock()
 	}
-	defer lock.Unlock()
-	lock.Lock()
-	q.data.PushFront(v)
 }
 
-func (q *KeyEventList) pop() interface{} {
-	defer lock.Unlock()
-	lock.Lock()
-	iter := q.data.Back()
-	v := iter.Value
-	q.data.Remove(iter)
-	return v
+// appendKeyEvent numbers e, stores it, publishes it to the stream subscribers
+// and passes it to the alerters. Events the store fails to keep are still
+// published.
+func appendKeyEvent(e KeyEvent) {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	keyEventSeq++
+	e.Seq = keyEventSeq
+	if err := keyEventStore.Append(e); err != nil {
+		slog.Warn("microbot: key event not stored", "type", e.Type, "seq", e.Seq, "error", err)
+	}
+	publishKeyEvent(e)
+	notifyAlerters(e)
+}
+
+// drainKeyEvents waits for the queued events to be recorded, including the
+// ones queued while it waits.
+func drainKeyEvents(ctx context.Context) error {
+	pendingMu.Lock()
+	if pendingKeyEvents == 0 {
+		pendingMu.Unlock()
+		return nil
+	}
+	if keyEventsDrained == nil {
+		keyEventsDrained = make(chan struct{})
+	}
+	done := keyEventsDrained
+	pendingMu.Unlock()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Append records e, evicting the oldest event if the ring is full.
+func (r *keyEventRing) Append(e KeyEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.size < len(r.events) {
+		r.events[(r.start+r.size)%len(r.events)] = e
+		r.size++
+		keyEventsSize.Set(float64(r.size))
+		return nil
+	}
+	keyEventsEvicted.WithLabelValues(r.events[r.start].Type, evictedLength).Inc()
+	r.events[r.start] = e
+	r.start = (r.start + 1) % len(r.events)
+	return nil
+}
+
+func (r *keyEventRing) Load(f KeyEventFilter) ([]KeyEvent, error) {
+	var events []KeyEvent
+	for _, e := range r.snapshot() {
+		if f.Match(e) {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+func (r *keyEventRing) LastSeq() (uint64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.size == 0 {
+		return 0, nil
+	}
+	return r.events[(r.start+r.size-1)%len(r.events)].Seq, nil
+}
+
+func (r *keyEventRing) Close() error {
+	return nil
+}
+
+// snapshot returns a copy of the events, oldest first.
+func (r *keyEventRing) snapshot() []KeyEvent {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	events := make([]KeyEvent, r.size)
+	for i := range events {
+		events[i] = r.events[(r.start+i)%len(r.events)]
+	}
+	return events
+}
+
+// resize changes the capacity of the ring, keeping the newest events.
+func (r *keyEventRing) resize(max int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	events := make([]KeyEvent, max)
+	skip := 0
+	if r.size > max {
+		skip = r.size - max
+	}
+	for i := 0; i < skip; i++ {
+		keyEventsEvicted.WithLabelValues(r.events[(r.start+i)%len(r.events)].Type, evictedLength).Inc()
+	}
+	n := 0
+	for i := skip; i < r.size; i++ {
+		events[n] = r.events[(r.start+i)%len(r.events)]
+		n++
+	}
+	r.events = events
+	r.start = 0
+	r.size = n
+	keyEventsSize.Set(float64(n))
+}
+
+// Delete removes the events of the given sequence numbers.
+func (r *keyEventRing) Delete(seqs []uint64) error {
+	deleted := make(map[uint64]bool, len(seqs))
+	for _, seq := range seqs {
+		deleted[seq] = true
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	events := make([]KeyEvent, len(r.events))
+	n := 0
+	for i := 0; i < r.size; i++ {
+		e := r.events[(r.start+i)%len(r.events)]
+		if !deleted[e.Seq] {
+			events[n] = e
+			n++
+		}
+	}
+	r.events = events
+	r.start = 0
+	r.size = n
+	keyEventsSize.Set(float64(n))
+	return nil
 }
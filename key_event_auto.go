@@ -0,0 +1,131 @@
+package microbot
+
+import (
+	"context"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Types of the key events recorded by microbot itself.
+const (
+	KeyEventPanic         = "panic"
+	KeyEventDBUnreachable = "db_unreachable"
+	KeyEventDBReachable   = "db_reachable"
+	KeyEventProcessStart  = "process_start"
+	KeyEventConfigReload  = "config_reload"
+)
+
+var (
+	autoMu       sync.RWMutex
+	autoDisabled = make(map[string]bool)
+
+	// processStart is the time the package was initialized, which stamps
+	// the process_start event, and processStarted is set once the event is
+	// recorded.
+	processStart   time.Time
+	processStarted atomic.Bool
+)
+
+// SetAuto enables or disables the key events of type t recorded by microbot
+// itself. They are all enabled by default. The process start is recorded
+// lazily, once the key events are configured or used: on the first call to
+// SetStore or EnableAlerts, or the first event recorded or read, whichever
+// comes first. It is stamped with the time the package was initialized, and
+// is never recorded by a process which does not use key events. Call
+// EnableAlerts before SetStore to be alerted of it.
+func (KeyEventList) SetAuto(t string, enabled bool) {
+	autoMu.Lock()
+	defer autoMu.Unlock()
+	if enabled {
+		delete(autoDisabled, t)
+	} else {
+		autoDisabled[t] = true
+	}
+}
+
+// RecordConfigReload records a config_reload event of the config read from
+// source, of severity error if the reload failed with err.
+func RecordConfigReload(ctx context.Context, source string, err error) {
+	if err != nil {
+		recordAutoKeyEvent(ctx, KeyEventConfigReload, "config reload failed: "+err.Error(),
+			WithSeverity(SeverityError),
+			WithAttribute("source", source),
+			WithAttribute("error", err.Error()))
+		return
+	}
+	recordAutoKeyEvent(ctx, KeyEventConfigReload, "config reloaded",
+		WithAttribute("source", source))
+}
+
+// recordAutoKeyEvent records an event unless its type is disabled. It must
+// be called directly by the recording function, so that the source is its
+// caller.
+func recordAutoKeyEvent(ctx context.Context, t string, c string, opts ...KeyEventOption) {
+	autoMu.RLock()
+	disabled := autoDisabled[t]
+	autoMu.RUnlock()
+	if disabled {
+		return
+	}
+	recordKeyEvent(ctx, t, c, opts)
+}
+
+// startKeyEvents records the process_start event unless it is already.
+func startKeyEvents() {
+	if processStarted.CompareAndSwap(false, true) {
+		recordProcessStart()
+	}
+}
+
+// recordProcessStart records the process_start event with the build info of
+// the binary, at the time the package was initialized.
+func recordProcessStart() {
+	attrs := map[string]string{
+		"pid":        strconv.Itoa(os.Getpid()),
+		"go_version": runtime.Version(),
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		attrs["hostname"] = hostname
+	}
+	content := "process started"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		attrs["path"] = info.Path
+		attrs["version"] = info.Main.Version
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision", "vcs.time", "vcs.modified":
+				attrs[s.Key] = s.Value
+			}
+		}
+		content += ": " + info.Path + " " + info.Main.Version
+	}
+	recordAutoKeyEvent(context.Background(), KeyEventProcessStart, content, WithAttributes(attrs),
+		func(e *KeyEvent) { e.Time = processStart })
+}
+
+// recordDBTransition records the change of reachability of a DB, the first
+// ping only when it fails. DBs are told apart by their index, which is added
+// to the events as the db_index attribute.
+func recordDBTransition(reachable map[int]bool, r DBPingResult) {
+	name := string(r.dbType)
+	index := strconv.Itoa(r.index)
+	was, known := reachable[r.index]
+	reachable[r.index] = r.err == nil
+	switch {
+	case r.err != nil && (!known || was):
+		recordAutoKeyEvent(context.Background(), KeyEventDBUnreachable, name+" #"+index+" is unreachable: "+r.err.Error(),
+			WithSeverity(SeverityError),
+			WithAttribute("db", name),
+			WithAttribute("db_index", index),
+			WithAttribute("error", r.err.Error()))
+	case r.err == nil && known && !was:
+		recordAutoKeyEvent(context.Background(), KeyEventDBReachable, name+" #"+index+" is reachable again",
+			WithAttribute("db", name),
+			WithAttribute("db_index", index))
+	}
+}
@@ -0,0 +1,82 @@
+package microbot
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/pangpanglabs/microbot/db"
+)
+
+// resetProcessStart lets the next use of the key events record the process
+// start again.
+func resetProcessStart(t *testing.T) {
+	processStarted.Store(false)
+	t.Cleanup(func() {
+		processStarted.Store(true)
+	})
+}
+
+func TestProcessStartDisabled(t *testing.T) {
+	resetKeyEvents(t)
+	resetProcessStart(t)
+	var l KeyEventList
+	l.SetAuto(KeyEventProcessStart, false)
+	defer l.SetAuto(KeyEventProcessStart, true)
+
+	l.New("recorded", "1")
+	if got := loadKeyEvents(t, KeyEventProcessStart); len(got) != 0 {
+		t.Errorf("recorded %d process_start events, want 0", len(got))
+	}
+}
+
+func TestProcessStartAlerted(t *testing.T) {
+	resetKeyEvents(t)
+	resetProcessStart(t)
+	sink := &recordingSink{}
+	a, err := EnableAlerts(AlerterConfig{Rules: []AlertRule{{Sink: sink, Types: []string{KeyEventProcessStart}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	KeyEventList{}.New("recorded", "1")
+	a.Close()
+
+	if len(sink.events) != 1 {
+		t.Fatalf("sent %d process_start events, want 1", len(sink.events))
+	}
+	if !sink.events[0].Time.Equal(processStart) {
+		t.Errorf("process_start at %v, want the package initialization at %v", sink.events[0].Time, processStart)
+	}
+	if sink.events[0].Attributes["pid"] == "" {
+		t.Errorf("process_start has no pid attribute: %v", sink.events[0].Attributes)
+	}
+	if got := loadKeyEvents(t, KeyEventProcessStart); len(got) != 1 {
+		t.Errorf("recorded %d process_start events, want 1", len(got))
+	}
+}
+
+func TestDBTransition(t *testing.T) {
+	resetKeyEvents(t)
+	reachable := make(map[int]bool)
+	down := errors.New("connection refused")
+
+	recordDBTransition(reachable, DBPingResult{index: 0, dbType: db.MYSQL, err: down})
+	recordDBTransition(reachable, DBPingResult{index: 1, dbType: db.MYSQL})
+	// The second DB of the same type does not hide the first one.
+	recordDBTransition(reachable, DBPingResult{index: 0, dbType: db.MYSQL, err: down})
+	recordDBTransition(reachable, DBPingResult{index: 1, dbType: db.MYSQL})
+
+	unreachable := loadKeyEvents(t, KeyEventDBUnreachable)
+	if len(unreachable) != 1 || unreachable[0].Attributes["db_index"] != "0" {
+		t.Fatalf("recorded %v, want one db_unreachable event of DB 0", unreachable)
+	}
+	if got := loadKeyEvents(t, KeyEventDBReachable); len(got) != 0 {
+		t.Fatalf("recorded %v, want no db_reachable event", got)
+	}
+
+	recordDBTransition(reachable, DBPingResult{index: 0, dbType: db.MYSQL})
+	recordDBTransition(reachable, DBPingResult{index: 1, dbType: db.MYSQL})
+	reachableAgain := loadKeyEvents(t, KeyEventDBReachable)
+	if len(reachableAgain) != 1 || reachableAgain[0].Attributes["db_index"] != "0" {
+		t.Errorf("recorded %v, want one db_reachable event of DB 0", reachableAgain)
+	}
+}
@@ -0,0 +1,282 @@
+package microbot
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultKeyEventLimit = 100
+	maxKeyEventLimit     = 1000
+
+	defaultKeyEventInterval = time.Minute
+	maxKeyEventBuckets      = 10000
+)
+
+type (
+	// KeyEventFilter selects key events. Zero fields match every event.
+	KeyEventFilter struct {
+		Types      []string
+		Severities []Severity
+		Since      time.Time
+		Until      time.Time
+		Search     string
+	}
+
+	// KeyEventQuery is a filter with paging and sort order.
+	KeyEventQuery struct {
+		KeyEventFilter
+		Limit  int
+		Offset int
+		// Cursor is the sequence number of the last event of the previous
+		// page, 0 for the first page.
+		Cursor uint64
+		Asc    bool
+		// Group is "type" or "severity" for counts of events per group per
+		// bucket of Interval, instead of pages.
+		Group    string
+		Interval time.Duration
+	}
+
+	KeyEventPage struct {
+		// Total is the number of events matching the filter.
+		Total  int        `json:"total"`
+		Events []KeyEvent `json:"events"`
+		// NextCursor is the cursor of the next page, 0 on the last page.
+		NextCursor uint64 `json:"nextCursor"`
+	}
+
+	KeyEventHistogram struct {
+		Since    time.Time        `json:"since"`
+		Until    time.Time        `json:"until"`
+		Interval string           `json:"interval"`
+		Buckets  []KeyEventBucket `json:"buckets"`
+	}
+
+	KeyEventBucket struct {
+		// Time is the start of the bucket.
+		Time   time.Time      `json:"time"`
+		Counts map[string]int `json:"counts"`
+	}
+)
+
+// Match reports whether e is selected by the filter.
+func (f KeyEventFilter) Match(e KeyEvent) bool {
+	if len(f.Types) > 0 {
+		found := false
+		for _, t := range f.Types {
+			if t == e.Type {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(f.Severities) > 0 {
+		found := false
+		for _, s := range f.Severities {
+			if s == e.Severity {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !e.Time.Before(f.Until) {
+		return false
+	}
+	if f.Search != "" && !strings.Contains(strings.ToLower(e.Content), strings.ToLower(f.Search)) {
+		return false
+	}
+	return true
+}
+
+// ParseKeyEventQuery reads a query from the parameters type and severity
+// (repeatable or comma separated), since and until (RFC 3339 or unix
+// seconds), q, limit, offset, cursor and order (asc or desc, the default),
+// or group (type or severity) and interval (a duration, 1m by default) for a
+// histogram.
+func ParseKeyEventQuery(r *http.Request) (KeyEventQuery, error) {
+	q := KeyEventQuery{Limit: defaultKeyEventLimit}
+	params := r.URL.Query()
+	for _, v := range params["type"] {
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				q.Types = append(q.Types, t)
+			}
+		}
+	}
+	for _, v := range params["severity"] {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name == "" {
+				continue
+			}
+			s, err := ParseSeverity(name)
+			if err != nil {
+				return q, errors.New("microbot: invalid severity")
+			}
+			q.Severities = append(q.Severities, s)
+		}
+	}
+	var err error
+	if q.Since, err = parseEventTime(params.Get("since")); err != nil {
+		return q, errors.New("microbot: invalid since")
+	}
+	if q.Until, err = parseEventTime(params.Get("until")); err != nil {
+		return q, errors.New("microbot: invalid until")
+	}
+	q.Search = params.Get("q")
+	if v := params.Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit < 1 {
+			return q, errors.New("microbot: invalid limit")
+		}
+		if q.Limit > maxKeyEventLimit {
+			q.Limit = maxKeyEventLimit
+		}
+	}
+	if v := params.Get("offset"); v != "" {
+		if q.Offset, err = strconv.Atoi(v); err != nil || q.Offset < 0 {
+			return q, errors.New("microbot: invalid offset")
+		}
+	}
+	if v := params.Get("cursor"); v != "" {
+		if q.Cursor, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return q, errors.New("microbot: invalid cursor")
+		}
+	}
+	switch params.Get("order") {
+	case "asc":
+		q.Asc = true
+	case "", "desc":
+	default:
+		return q, errors.New("microbot: invalid order")
+	}
+	switch q.Group = params.Get("group"); q.Group {
+	case "", "type", "severity":
+	default:
+		return q, errors.New("microbot: invalid group")
+	}
+	q.Interval = defaultKeyEventInterval
+	if v := params.Get("interval"); v != "" {
+		if q.Interval, err = time.ParseDuration(v); err != nil || q.Interval < time.Second {
+			return q, errors.New("microbot: invalid interval")
+		}
+	}
+	return q, nil
+}
+
+func parseEventTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if sec, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// Page applies the query to events, which are sorted by sequence number.
+func (q KeyEventQuery) Page(events []KeyEvent) KeyEventPage {
+	var matched []KeyEvent
+	for _, e := range events {
+		if q.Match(e) {
+			matched = append(matched, e)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		if q.Asc {
+			return matched[i].Seq < matched[j].Seq
+		}
+		return matched[i].Seq > matched[j].Seq
+	})
+
+	page := KeyEventPage{Total: len(matched), Events: []KeyEvent{}}
+	rest := matched
+	if q.Cursor != 0 {
+		i := sort.Search(len(rest), func(i int) bool {
+			if q.Asc {
+				return rest[i].Seq > q.Cursor
+			}
+			return rest[i].Seq < q.Cursor
+		})
+		rest = rest[i:]
+	}
+	if q.Offset >= len(rest) {
+		return page
+	}
+	rest = rest[q.Offset:]
+	if len(rest) > q.Limit {
+		page.NextCursor = rest[q.Limit-1].Seq
+		rest = rest[:q.Limit]
+	}
+	page.Events = rest
+	return page
+}
+
+// Histogram counts the events matching the query per group and per bucket of
+// the interval, from since, or the oldest event, until until, or now. Buckets
+// are aligned on the interval and empty ones are included.
+func (q KeyEventQuery) Histogram(events []KeyEvent, now time.Time) (KeyEventHistogram, error) {
+	interval := q.Interval
+	if interval <= 0 {
+		interval = defaultKeyEventInterval
+	}
+	var matched []KeyEvent
+	for _, e := range events {
+		if q.Match(e) {
+			matched = append(matched, e)
+		}
+	}
+	until := q.Until
+	if until.IsZero() {
+		until = now
+	}
+	since := q.Since
+	if since.IsZero() {
+		since = until
+		for _, e := range matched {
+			if e.Time.Before(since) {
+				since = e.Time
+			}
+		}
+	}
+	h := KeyEventHistogram{Since: since, Until: until, Interval: interval.String()}
+	if until.Before(since) {
+		return h, errors.New("microbot: until is before since")
+	}
+	start := since.Truncate(interval)
+	n := int(until.Sub(start)/interval) + 1
+	if n > maxKeyEventBuckets {
+		return h, errors.New("microbot: too many buckets, increase the interval")
+	}
+	h.Buckets = make([]KeyEventBucket, n)
+	for i := range h.Buckets {
+		h.Buckets[i] = KeyEventBucket{
+			Time:   start.Add(time.Duration(i) * interval),
+			Counts: map[string]int{},
+		}
+	}
+	for _, e := range matched {
+		i := int(e.Time.Sub(start) / interval)
+		if i < 0 || i >= n {
+			continue
+		}
+		key := e.Type
+		if q.Group == "severity" {
+			key = e.Severity.String()
+		}
+		h.Buckets[i].Counts[key]++
+	}
+	return h, nil
+}
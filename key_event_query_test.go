@@ -0,0 +1,113 @@
+package microbot
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestKeyEventHistogram(t *testing.T) {
+	base := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	events := []KeyEvent{
+		{Seq: 1, Type: "a", Time: base.Add(10 * time.Second), Severity: SeverityInfo},
+		{Seq: 2, Type: "a", Time: base.Add(50 * time.Second), Severity: SeverityInfo},
+		{Seq: 3, Type: "b", Time: base.Add(70 * time.Second), Severity: SeverityInfo},
+		{Seq: 4, Type: "a", Time: base.Add(130 * time.Second), Severity: SeverityError},
+		// Out of the range.
+		{Seq: 5, Type: "a", Time: base.Add(10 * time.Minute), Severity: SeverityInfo},
+	}
+	q := KeyEventQuery{
+		KeyEventFilter: KeyEventFilter{Since: base.Add(5 * time.Second), Until: base.Add(3 * time.Minute)},
+		Interval:       time.Minute,
+	}
+
+	tests := []struct {
+		group string
+		want  []map[string]int
+	}{
+		{"type", []map[string]int{{"a": 2}, {"b": 1}, {"a": 1}, {}}},
+		{"severity", []map[string]int{
+			{SeverityInfo.String(): 2},
+			{SeverityInfo.String(): 1},
+			{SeverityError.String(): 1},
+			{},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.group, func(t *testing.T) {
+			q.Group = tt.group
+			h, err := q.Histogram(events, base.Add(time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if h.Interval != "1m0s" {
+				t.Errorf("interval = %s, want 1m0s", h.Interval)
+			}
+			// Buckets are aligned on the interval, the empty ones included.
+			var counts []map[string]int
+			for i, b := range h.Buckets {
+				if want := base.Add(time.Duration(i) * time.Minute); !b.Time.Equal(want) {
+					t.Errorf("bucket %d starts at %v, want %v", i, b.Time, want)
+				}
+				counts = append(counts, b.Counts)
+			}
+			if !reflect.DeepEqual(counts, tt.want) {
+				t.Errorf("counts = %v, want %v", counts, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyEventHistogramErrors(t *testing.T) {
+	now := time.Now()
+	q := KeyEventQuery{KeyEventFilter: KeyEventFilter{Since: now, Until: now.Add(-time.Minute)}}
+	if _, err := q.Histogram(nil, now); err == nil {
+		t.Error("no error for until before since")
+	}
+	q = KeyEventQuery{KeyEventFilter: KeyEventFilter{Since: now.Add(-time.Hour)}, Interval: time.Millisecond}
+	if _, err := q.Histogram(nil, now); err == nil {
+		t.Error("no error for too many buckets")
+	}
+}
+
+func TestKeyEventControllerHistogram(t *testing.T) {
+	resetKeyEvents(t)
+	var l KeyEventList
+	l.New("grouped", "1")
+	l.New("grouped", "2")
+	l.New("ungrouped", "3")
+
+	w := httptest.NewRecorder()
+	KeyEventController().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?group=type&interval=1m&type=grouped", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Result  KeyEventHistogram `json:"result"`
+		Success bool              `json:"success"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || !resp.Success {
+		t.Fatalf("%v: %s", err, w.Body)
+	}
+	total := 0
+	for _, b := range resp.Result.Buckets {
+		for key, n := range b.Counts {
+			if key != "grouped" {
+				t.Errorf("counted %d events of %s", n, key)
+			}
+			total += n
+		}
+	}
+	if total != 2 {
+		t.Errorf("counted %d events, want 2", total)
+	}
+
+	w = httptest.NewRecorder()
+	KeyEventController().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?group=content", nil))
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Success {
+		t.Errorf("invalid group answered %s", w.Body)
+	}
+}
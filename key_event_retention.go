@@ -0,0 +1,210 @@
+package microbot
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Reasons of the evictions of key events.
+const (
+	evictedLength = "length"
+	evictedCount  = "count"
+	evictedAge    = "age"
+	evictedBytes  = "bytes"
+)
+
+type (
+	// RetentionPolicy limits the events kept of a type. Zero fields are not
+	// limits.
+	RetentionPolicy struct {
+		MaxCount int           `yaml:"max_count"`
+		MaxAge   time.Duration `yaml:"max_age"`
+		// MaxBytes is the total size of the events in JSON.
+		MaxBytes int64 `yaml:"max_bytes"`
+	}
+
+	// RetentionConfig defines the retention of key events, applied by a
+	// background compactor. The in-memory store still keeps at most the
+	// length set by KeyEventList.SetLength.
+	RetentionConfig struct {
+		// Types maps event types to their policy.
+		// Optional. Default value nil.
+		Types map[string]RetentionPolicy `yaml:"types"`
+
+		// Default is the policy of the types missing from Types.
+		// Optional. Default value no limit.
+		Default RetentionPolicy `yaml:"default"`
+
+		// Interval between compactions.
+		// Optional. Default value 1 minute.
+		Interval time.Duration `yaml:"interval"`
+	}
+
+	// KeyEventDeleter is implemented by the stores which support retention.
+	KeyEventDeleter interface {
+		// Delete removes the events of the given sequence numbers.
+		Delete(seqs []uint64) error
+	}
+)
+
+var (
+	// DefaultRetentionConfig is the default retention config.
+	DefaultRetentionConfig = RetentionConfig{
+		Interval: time.Minute,
+	}
+
+	retentionMu     sync.Mutex
+	retention       RetentionConfig
+	compactorUpdate chan time.Duration
+	compactorStop   = make(chan struct{})
+	compactorDone   chan struct{}
+)
+
+// SetRetention sets the retention policies, and starts the compactor which
+// applies them on the current store.
+func (KeyEventList) SetRetention(config RetentionConfig) {
+	// Defaults
+	if config.Interval == 0 {
+		config.Interval = DefaultRetentionConfig.Interval
+	}
+	retentionMu.Lock()
+	defer retentionMu.Unlock()
+	retention = config
+	if compactorDone == nil {
+		compactorUpdate = make(chan time.Duration, 1)
+		compactorDone = make(chan struct{})
+		go runCompactor(config.Interval, compactorUpdate, compactorStop, compactorDone)
+		return
+	}
+	select {
+	case <-compactorUpdate:
+	default:
+	}
+	compactorUpdate <- config.Interval
+}
+
+// Compact applies the retention policies once.
+func (KeyEventList) Compact() error {
+	return compactKeyEvents(time.Now())
+}
+
+func runCompactor(interval time.Duration, update <-chan time.Duration, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := compactKeyEvents(time.Now()); err != nil {
+				slog.Warn("microbot: key event compaction failed", "error", err)
+			}
+		case interval := <-update:
+			ticker.Reset(interval)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// stopCompactor stops the compactor if it was started, and waits for the
+// compaction in progress until ctx ends. retentionMu is released before
+// waiting, as compactions lock it.
+func stopCompactor(ctx context.Context) error {
+	retentionMu.Lock()
+	select {
+	case <-compactorStop:
+		retentionMu.Unlock()
+		return nil
+	default:
+	}
+	close(compactorStop)
+	done := compactorDone
+	retentionMu.Unlock()
+	if done == nil {
+		return nil
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// compactKeyEvents deletes the events beyond the policy of their type,
+// keeping the newest ones.
+func compactKeyEvents(now time.Time) error {
+	retentionMu.Lock()
+	config := retention
+	retentionMu.Unlock()
+
+	store := currentKeyEventStore()
+	deleter, ok := store.(KeyEventDeleter)
+	if !ok {
+		return nil
+	}
+	events, err := store.Load(KeyEventFilter{})
+	if err != nil {
+		return err
+	}
+	byType := make(map[string][]KeyEvent)
+	for _, e := range events {
+		byType[e.Type] = append(byType[e.Type], e)
+	}
+
+	var seqs []uint64
+	evicted := make(map[[2]string]int)
+	for t, events := range byType {
+		policy, ok := config.Types[t]
+		if !ok {
+			policy = config.Default
+		}
+		count := 0
+		var bytes int64
+		for i := len(events) - 1; i >= 0; i-- {
+			e := events[i]
+			reason := ""
+			switch {
+			case policy.MaxAge > 0 && now.Sub(e.Time) > policy.MaxAge:
+				reason = evictedAge
+			case policy.MaxCount > 0 && count >= policy.MaxCount:
+				reason = evictedCount
+			default:
+				size := keyEventSize(e)
+				if policy.MaxBytes > 0 && bytes+size > policy.MaxBytes {
+					reason = evictedBytes
+					// Older events are evicted too, even if smaller.
+					bytes = policy.MaxBytes
+					break
+				}
+				count++
+				bytes += size
+			}
+			if reason != "" {
+				seqs = append(seqs, e.Seq)
+				evicted[[2]string{t, reason}]++
+			}
+		}
+	}
+	if len(seqs) == 0 {
+		return nil
+	}
+	if err := deleter.Delete(seqs); err != nil {
+		return err
+	}
+	for k, n := range evicted {
+		keyEventsEvicted.WithLabelValues(k[0], k[1]).Add(float64(n))
+	}
+	return nil
+}
+
+func keyEventSize(e KeyEvent) int64 {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return 0
+	}
+	return int64(len(b))
+}
@@ -0,0 +1,105 @@
+package microbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// resetCompactor stops the compactor when the test ends, and lets the next
+// test start one again.
+func resetCompactor(t *testing.T) {
+	t.Cleanup(func() {
+		stopCompactor(context.Background())
+		retentionMu.Lock()
+		defer retentionMu.Unlock()
+		retention = RetentionConfig{}
+		compactorStop = make(chan struct{})
+		compactorDone = nil
+	})
+}
+
+func TestCompactKeyEvents(t *testing.T) {
+	resetKeyEvents(t)
+	resetCompactor(t)
+	var l KeyEventList
+	evicted := keyEventsEvicted.WithLabelValues("compacted", evictedCount)
+	before := testutil.ToFloat64(evicted)
+	l.SetRetention(RetentionConfig{
+		Types:    map[string]RetentionPolicy{"compacted": {MaxCount: 2}},
+		Interval: time.Hour,
+	})
+	for i := 0; i < 5; i++ {
+		l.New("compacted", fmt.Sprint(i))
+		l.New("uncompacted", fmt.Sprint(i))
+	}
+	if err := l.Compact(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, e := range loadKeyEvents(t, "compacted") {
+		got = append(got, e.Content)
+	}
+	if fmt.Sprint(got) != "[3 4]" {
+		t.Errorf("kept %v, want the 2 newest", got)
+	}
+	if n := len(loadKeyEvents(t, "uncompacted")); n != 5 {
+		t.Errorf("kept %d events without policy, want 5", n)
+	}
+	if n := testutil.ToFloat64(evicted) - before; n != 3 {
+		t.Errorf("evicted = %v, want 3", n)
+	}
+}
+
+// blockingStore blocks Load until release is closed.
+type blockingStore struct {
+	*keyEventRing
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingStore) Load(f KeyEventFilter) ([]KeyEvent, error) {
+	select {
+	case s.entered <- struct{}{}:
+	default:
+	}
+	<-s.release
+	return s.keyEventRing.Load(f)
+}
+
+func TestStopCompactorDuringCompaction(t *testing.T) {
+	resetKeyEvents(t)
+	resetCompactor(t)
+	store := &blockingStore{
+		keyEventRing: newKeyEventRing(10),
+		entered:      make(chan struct{}),
+		release:      make(chan struct{}),
+	}
+	var l KeyEventList
+	if err := l.SetStore(store); err != nil {
+		t.Fatal(err)
+	}
+	l.SetRetention(RetentionConfig{Interval: time.Millisecond})
+	<-store.entered
+
+	// A compaction is in progress and another one is due, stopping must
+	// neither deadlock nor outlive ctx.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := stopCompactor(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("stopCompactor = %v, want deadline exceeded", err)
+	}
+	l.SetRetention(RetentionConfig{Interval: time.Hour})
+
+	close(store.release)
+	select {
+	case <-compactorDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("compactor did not stop")
+	}
+}
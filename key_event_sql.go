@@ -0,0 +1,355 @@
+package microbot
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pangpanglabs/microbot/db"
+)
+
+type (
+	// SQLKeyEventStoreConfig defines the config for SQLKeyEventStore.
+	SQLKeyEventStoreConfig struct {
+		// DBType selects the DB registered with RegisterDB which events are
+		// written to.
+		// Optional. Default value the type of the first registered DB.
+		DBType db.DBType `yaml:"db_type"`
+
+		// Table of the events, created if it does not exist.
+		// Optional. Default value "microbot_key_events".
+		Table string `yaml:"table"`
+	}
+
+	// SQLKeyEventStore writes events to a table of a registered DB. Events
+	// are inserted by the background writer of KeyEventList.SetStore, unless
+	// KeyEventList.SetBuffer(0) makes recording wait for the insert.
+	SQLKeyEventStore struct {
+		db      *sql.DB
+		dbType  db.DBType
+		table   string
+		columns string
+	}
+)
+
+var (
+	// DefaultSQLKeyEventStoreConfig is the default SQLKeyEventStore config.
+	DefaultSQLKeyEventStoreConfig = SQLKeyEventStoreConfig{
+		Table: "microbot_key_events",
+	}
+
+	sqlTableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+	// likeEscaper escapes the wildcards of LIKE patterns with '!', which
+	// needs no escaping in the string literals of any dialect.
+	likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+	keyEventColumns = []string{
+		"seq", "event_type", "severity", "content", "event_time",
+		"attributes", "source", "request_id", "trace_id",
+	}
+)
+
+func NewSQLKeyEventStore(config SQLKeyEventStoreConfig) (*SQLKeyEventStore, error) {
+	// Defaults
+	if config.Table == "" {
+		config.Table = DefaultSQLKeyEventStoreConfig.Table
+	}
+	if !sqlTableName.MatchString(config.Table) {
+		return nil, errors.New("microbot: invalid SQLKeyEventStore Table")
+	}
+	var dialect db.Dialect
+	for _, d := range dialects {
+		if config.DBType == "" || d.DBType() == config.DBType {
+			dialect = d
+			break
+		}
+	}
+	if dialect == nil {
+		return nil, errors.New("microbot: no registered DB for SQLKeyEventStore")
+	}
+
+	s := &SQLKeyEventStore{
+		db:      dialect.DB(),
+		dbType:  dialect.DBType(),
+		table:   config.Table,
+		columns: strings.Join(keyEventColumns, ", "),
+	}
+	ddl, err := s.createTable()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.db.Exec(ddl); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// createTable returns the DDL creating the table unless it exists. Times are
+// stored as unix nanoseconds and attributes as JSON, which every dialect
+// supports.
+func (s *SQLKeyEventStore) createTable() (string, error) {
+	switch s.dbType {
+	case db.POSTGRES, db.SQLITE:
+		return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	seq BIGINT PRIMARY KEY,
+	event_type VARCHAR(255) NOT NULL,
+	severity INTEGER NOT NULL,
+	content TEXT NOT NULL,
+	event_time BIGINT NOT NULL,
+	attributes TEXT,
+	source VARCHAR(255),
+	request_id VARCHAR(255),
+	trace_id VARCHAR(32)
+)`, s.table), nil
+	case db.MYSQL:
+		return fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	seq BIGINT UNSIGNED PRIMARY KEY,
+	event_type VARCHAR(255) NOT NULL,
+	severity INT NOT NULL,
+	content TEXT NOT NULL,
+	event_time BIGINT NOT NULL,
+	attributes TEXT,
+	source VARCHAR(255),
+	request_id VARCHAR(255),
+	trace_id VARCHAR(32)
+)`, s.table), nil
+	case db.MSSQL:
+		return fmt.Sprintf(`IF OBJECT_ID(N'%[1]s', N'U') IS NULL CREATE TABLE %[1]s (
+	seq BIGINT PRIMARY KEY,
+	event_type NVARCHAR(255) NOT NULL,
+	severity INT NOT NULL,
+	content NVARCHAR(MAX) NOT NULL,
+	event_time BIGINT NOT NULL,
+	attributes NVARCHAR(MAX),
+	source NVARCHAR(255),
+	request_id NVARCHAR(255),
+	trace_id NVARCHAR(32)
+)`, s.table), nil
+	case db.ORACLE:
+		// ORA-00955 is raised when the table exists. Oracle stores empty
+		// strings as NULL, so content is nullable.
+		return fmt.Sprintf(`BEGIN
+	EXECUTE IMMEDIATE 'CREATE TABLE %s (
+		seq NUMBER(20) PRIMARY KEY,
+		event_type VARCHAR2(255) NOT NULL,
+		severity NUMBER(5) NOT NULL,
+		content CLOB,
+		event_time NUMBER(20) NOT NULL,
+		attributes CLOB,
+		source VARCHAR2(255),
+		request_id VARCHAR2(255),
+		trace_id VARCHAR2(32)
+	)';
+EXCEPTION
+	WHEN OTHERS THEN
+		IF SQLCODE != -955 THEN
+			RAISE;
+		END IF;
+END;`, s.table), nil
+	}
+	return "", errors.New("microbot: Unsupported DBType")
+}
+
+// placeholder returns the n-th bind parameter, counted from 1.
+func (s *SQLKeyEventStore) placeholder(n int) string {
+	switch s.dbType {
+	case db.POSTGRES:
+		return fmt.Sprintf("$%d", n)
+	case db.MSSQL:
+		return fmt.Sprintf("@p%d", n)
+	case db.ORACLE:
+		return fmt.Sprintf(":%d", n)
+	}
+	return "?"
+}
+
+func (s *SQLKeyEventStore) Append(e KeyEvent) error {
+	var attributes sql.NullString
+	if len(e.Attributes) > 0 {
+		b, err := json.Marshal(e.Attributes)
+		if err != nil {
+			return err
+		}
+		attributes = sql.NullString{String: string(b), Valid: true}
+	}
+	params := make([]string, len(keyEventColumns))
+	for i := range params {
+		params[i] = s.placeholder(i + 1)
+	}
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", s.table, s.columns, strings.Join(params, ", "))
+	_, err := s.db.Exec(query,
+		int64(e.Seq), e.Type, int(e.Severity), e.Content, e.Time.UnixNano(),
+		attributes, e.Source, e.RequestID, e.TraceID)
+	return err
+}
+
+// Load selects the events matching f in SQL.
+func (s *SQLKeyEventStore) Load(f KeyEventFilter) ([]KeyEvent, error) {
+	where, args := s.where(f)
+	return s.query(fmt.Sprintf("SELECT %s FROM %s%s ORDER BY seq", s.columns, s.table, where), args...)
+}
+
+// Query counts and pages the events in SQL. Search is matched with LIKE on
+// the lower case content, which may fold cases differently than Go for
+// characters beyond ASCII.
+func (s *SQLKeyEventStore) Query(q KeyEventQuery) (KeyEventPage, error) {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultKeyEventLimit
+	}
+	page := KeyEventPage{Events: []KeyEvent{}}
+	where, args := s.where(q.KeyEventFilter)
+	if err := s.db.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s%s", s.table, where), args...).Scan(&page.Total); err != nil {
+		return page, err
+	}
+
+	order := "DESC"
+	if q.Asc {
+		order = "ASC"
+	}
+	if q.Cursor != 0 {
+		op := "<"
+		if q.Asc {
+			op = ">"
+		}
+		args = append(args, int64(q.Cursor))
+		cond := "seq " + op + " " + s.placeholder(len(args))
+		if where == "" {
+			where = " WHERE " + cond
+		} else {
+			where += " AND " + cond
+		}
+	}
+	// One more event than the page tells whether there is a next one.
+	query := fmt.Sprintf("SELECT %s FROM %s%s ORDER BY seq %s", s.columns, s.table, where, order)
+	switch s.dbType {
+	case db.MSSQL, db.ORACLE:
+		query += fmt.Sprintf(" OFFSET %d ROWS FETCH NEXT %d ROWS ONLY", q.Offset, limit+1)
+	default:
+		query += fmt.Sprintf(" LIMIT %d OFFSET %d", limit+1, q.Offset)
+	}
+	events, err := s.query(query, args...)
+	if err != nil {
+		return page, err
+	}
+	if len(events) > limit {
+		page.NextCursor = events[limit-1].Seq
+		events = events[:limit]
+	}
+	if len(events) > 0 {
+		page.Events = events
+	}
+	return page, nil
+}
+
+// where returns the WHERE clause selecting the events matching f, empty if f
+// selects every event, and its arguments.
+func (s *SQLKeyEventStore) where(f KeyEventFilter) (string, []interface{}) {
+	var where []string
+	var args []interface{}
+	if len(f.Types) > 0 {
+		params := make([]string, len(f.Types))
+		for i, t := range f.Types {
+			args = append(args, t)
+			params[i] = s.placeholder(len(args))
+		}
+		where = append(where, "event_type IN ("+strings.Join(params, ", ")+")")
+	}
+	if len(f.Severities) > 0 {
+		params := make([]string, len(f.Severities))
+		for i, sev := range f.Severities {
+			args = append(args, int(sev))
+			params[i] = s.placeholder(len(args))
+		}
+		where = append(where, "severity IN ("+strings.Join(params, ", ")+")")
+	}
+	if !f.Since.IsZero() {
+		args = append(args, f.Since.UnixNano())
+		where = append(where, "event_time >= "+s.placeholder(len(args)))
+	}
+	if !f.Until.IsZero() {
+		args = append(args, f.Until.UnixNano())
+		where = append(where, "event_time < "+s.placeholder(len(args)))
+	}
+	if f.Search != "" {
+		args = append(args, "%"+likeEscaper.Replace(strings.ToLower(f.Search))+"%")
+		where = append(where, "LOWER(content) LIKE "+s.placeholder(len(args))+" ESCAPE '!'")
+	}
+	if len(where) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(where, " AND "), args
+}
+
+func (s *SQLKeyEventStore) query(query string, args ...interface{}) ([]KeyEvent, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var events []KeyEvent
+	for rows.Next() {
+		var (
+			e                                           KeyEvent
+			seq, nanos                                  int64
+			severity                                    int
+			content, attributes, source, reqID, traceID sql.NullString
+		)
+		if err := rows.Scan(&seq, &e.Type, &severity, &content, &nanos,
+			&attributes, &source, &reqID, &traceID); err != nil {
+			return nil, err
+		}
+		e.Seq = uint64(seq)
+		e.Severity = Severity(severity)
+		e.Time = time.Unix(0, nanos)
+		e.Content, e.Source, e.RequestID, e.TraceID = content.String, source.String, reqID.String, traceID.String
+		if attributes.Valid && attributes.String != "" {
+			if err := json.Unmarshal([]byte(attributes.String), &e.Attributes); err != nil {
+				return nil, err
+			}
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// Delete removes the events of the given sequence numbers, in batches.
+func (s *SQLKeyEventStore) Delete(seqs []uint64) error {
+	const batch = 500
+	for len(seqs) > 0 {
+		n := len(seqs)
+		if n > batch {
+			n = batch
+		}
+		params := make([]string, n)
+		args := make([]interface{}, n)
+		for i, seq := range seqs[:n] {
+			params[i] = s.placeholder(i + 1)
+			args[i] = int64(seq)
+		}
+		query := fmt.Sprintf("DELETE FROM %s WHERE seq IN (%s)", s.table, strings.Join(params, ", "))
+		if _, err := s.db.Exec(query, args...); err != nil {
+			return err
+		}
+		seqs = seqs[n:]
+	}
+	return nil
+}
+
+func (s *SQLKeyEventStore) LastSeq() (uint64, error) {
+	var seq sql.NullInt64
+	if err := s.db.QueryRow(fmt.Sprintf("SELECT MAX(seq) FROM %s", s.table)).Scan(&seq); err != nil {
+		return 0, err
+	}
+	return uint64(seq.Int64), nil
+}
+
+// Close does not close the DB, which belongs to the application.
+func (s *SQLKeyEventStore) Close() error {
+	return nil
+}
@@ -0,0 +1,448 @@
+package microbot
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// KeyEventStore keeps the recorded key events. The default store is an
+// in-memory list of DefaultListMax events.
+type KeyEventStore interface {
+	// Append stores e, whose sequence number is set.
+	Append(e KeyEvent) error
+	// Load returns the stored events matching f, oldest first.
+	Load(f KeyEventFilter) ([]KeyEvent, error)
+	// LastSeq returns the sequence number of the newest stored event, 0 if
+	// there is none, so that numbering continues after a restart.
+	LastSeq() (uint64, error)
+	Close() error
+}
+
+// KeyEventQuerier is implemented by the stores which page events themselves,
+// instead of loading every matching event for KeyEventController.
+type KeyEventQuerier interface {
+	// Query returns the page of the events selected by q.
+	Query(q KeyEventQuery) (KeyEventPage, error)
+}
+
+type (
+	// FileKeyEventStoreConfig defines the config for FileKeyEventStore.
+	FileKeyEventStoreConfig struct {
+		// Path of the file events are appended to. Rotated files are named
+		// Path.1, Path.2 and so on, from the newest.
+		// Required.
+		Path string `yaml:"path"`
+
+		// MaxSize of the file in bytes, beyond which it is rotated.
+		// Optional. Default value 10 MB.
+		MaxSize int64 `yaml:"max_size"`
+
+		// MaxFiles is the number of rotated files kept.
+		// Optional. Default value 5.
+		MaxFiles int `yaml:"max_files"`
+	}
+
+	// FileKeyEventStore appends events to a file as JSON lines, rotating it
+	// when it grows beyond MaxSize.
+	FileKeyEventStore struct {
+		config FileKeyEventStoreConfig
+		mu     sync.Mutex
+		file   *os.File
+		size   int64
+		closed bool
+	}
+)
+
+var (
+	// DefaultFileKeyEventStoreConfig is the default FileKeyEventStore config.
+	DefaultFileKeyEventStoreConfig = FileKeyEventStoreConfig{
+		MaxSize:  10 << 20,
+		MaxFiles: 5,
+	}
+)
+
+// SetStore replaces the store of the events recorded from now on. Sequence
+// numbers continue after the newest event of s. The previous store is not
+// closed.
+//
+// Stores other than the in-memory one write to a file or a DB, so unless
+// SetBuffer has been called, events are then recorded through a buffer of
+// DefaultStoreBuffer events instead of waiting for the write in New.
+func (KeyEventList) SetStore(s KeyEventStore) error {
+	if s == nil {
+		return errors.New("microbot: nil KeyEventStore")
+	}
+	seq, err := s.LastSeq()
+	if err != nil {
+		return err
+	}
+	if s != KeyEventStore(keyEvents) {
+		queueLock.Lock()
+		if !bufferSet && keyEventQueue == nil {
+			startKeyEventQueue(DefaultStoreBuffer)
+		}
+		queueLock.Unlock()
+	}
+	storeMu.Lock()
+	keyEventStore = s
+	if seq > keyEventSeq {
+		keyEventSeq = seq
+	}
+	storeMu.Unlock()
+	startKeyEvents()
+	return nil
+}
+
+func currentKeyEventStore() KeyEventStore {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	return keyEventStore
+}
+
+// queryKeyEvents returns the page of the events selected by q, paged by the
+// store if it is a KeyEventQuerier.
+func queryKeyEvents(store KeyEventStore, q KeyEventQuery) (KeyEventPage, error) {
+	if querier, ok := store.(KeyEventQuerier); ok {
+		return querier.Query(q)
+	}
+	events, err := store.Load(q.KeyEventFilter)
+	if err != nil {
+		return KeyEventPage{}, err
+	}
+	return q.Page(events), nil
+}
+
+// loadKeyEventsAfter returns the events matching f with a sequence number
+// greater than last, oldest first, a page at a time if the store is a
+// KeyEventQuerier.
+func loadKeyEventsAfter(store KeyEventStore, f KeyEventFilter, last uint64) ([]KeyEvent, error) {
+	var events []KeyEvent
+	if querier, ok := store.(KeyEventQuerier); ok {
+		q := KeyEventQuery{KeyEventFilter: f, Limit: maxKeyEventLimit, Cursor: last, Asc: true}
+		for {
+			page, err := querier.Query(q)
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, page.Events...)
+			if page.NextCursor == 0 {
+				return events, nil
+			}
+			q.Cursor = page.NextCursor
+		}
+	}
+	loaded, err := store.Load(f)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range loaded {
+		if e.Seq > last {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+func NewFileKeyEventStore(config FileKeyEventStoreConfig) (*FileKeyEventStore, error) {
+	if config.Path == "" {
+		return nil, errors.New("microbot: FileKeyEventStore Path is required")
+	}
+	// Defaults
+	if config.MaxSize == 0 {
+		config.MaxSize = DefaultFileKeyEventStoreConfig.MaxSize
+	}
+	if config.MaxFiles == 0 {
+		config.MaxFiles = DefaultFileKeyEventStoreConfig.MaxFiles
+	}
+	s := &FileKeyEventStore{config: config}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileKeyEventStore) open() error {
+	f, err := os.OpenFile(s.config.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.file = f
+	s.size = info.Size()
+	return nil
+}
+
+func (s *FileKeyEventStore) Append(e KeyEvent) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errors.New("microbot: FileKeyEventStore closed")
+	}
+	if s.file == nil {
+		// A previous rotation failed to reopen Path.
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+	if s.size > 0 && s.size+int64(len(line)) > s.config.MaxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	return err
+}
+
+// rotate renames Path to Path.1, shifting the rotated files and removing
+// the oldest one, and opens a new Path. Path is reopened as is if the
+// renames fail.
+func (s *FileKeyEventStore) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	s.file = nil
+	err := s.shift()
+	if oerr := s.open(); err == nil {
+		err = oerr
+	}
+	return err
+}
+
+func (s *FileKeyEventStore) shift() error {
+	os.Remove(s.rotatedPath(s.config.MaxFiles))
+	for i := s.config.MaxFiles - 1; i >= 1; i-- {
+		if err := os.Rename(s.rotatedPath(i), s.rotatedPath(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(s.config.Path, s.rotatedPath(1))
+}
+
+func (s *FileKeyEventStore) rotatedPath(i int) string {
+	return fmt.Sprintf("%s.%d", s.config.Path, i)
+}
+
+// Load reads the rotated files and then the current one.
+func (s *FileKeyEventStore) Load(f KeyEventFilter) ([]KeyEvent, error) {
+	var events []KeyEvent
+	err := s.scan(func(e KeyEvent) {
+		if f.Match(e) {
+			events = append(events, e)
+		}
+	})
+	return events, err
+}
+
+// Query scans the files like Load, but keeps only the events of the page in
+// memory.
+func (s *FileKeyEventStore) Query(q KeyEventQuery) (KeyEventPage, error) {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultKeyEventLimit
+	}
+	page := KeyEventPage{Events: []KeyEvent{}}
+	// window holds the first events after the cursor in ascending order, or
+	// the last events before it in descending order, one more than the page
+	// to tell whether there is a next one.
+	want := q.Offset + limit + 1
+	var window []KeyEvent
+	err := s.scan(func(e KeyEvent) {
+		if !q.Match(e) {
+			return
+		}
+		page.Total++
+		if q.Asc {
+			if e.Seq > q.Cursor && len(window) < want {
+				window = append(window, e)
+			}
+			return
+		}
+		if q.Cursor != 0 && e.Seq >= q.Cursor {
+			return
+		}
+		window = append(window, e)
+		if len(window) == 2*want {
+			window = append(window[:0], window[want:]...)
+		}
+	})
+	if err != nil {
+		return page, err
+	}
+	if !q.Asc {
+		if len(window) > want {
+			window = window[len(window)-want:]
+		}
+		for i, j := 0, len(window)-1; i < j; i, j = i+1, j-1 {
+			window[i], window[j] = window[j], window[i]
+		}
+	}
+	if q.Offset >= len(window) {
+		return page, nil
+	}
+	rest := window[q.Offset:]
+	if len(rest) > limit {
+		page.NextCursor = rest[limit-1].Seq
+		rest = rest[:limit]
+	}
+	page.Events = rest
+	return page, nil
+}
+
+// scan calls fn with the events of the rotated files and then of the current
+// one, oldest first.
+func (s *FileKeyEventStore) scan(fn func(e KeyEvent)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := s.config.MaxFiles; i >= 0; i-- {
+		if err := scanKeyEvents(s.path(i), fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// path returns the current file for 0, and the i-th rotated file otherwise.
+func (s *FileKeyEventStore) path(i int) string {
+	if i == 0 {
+		return s.config.Path
+	}
+	return s.rotatedPath(i)
+}
+
+// scanKeyEvents calls fn with the events of the file at path, if it exists.
+// Lines which fail to decode, such as a line cut by a crash, are skipped.
+func scanKeyEvents(path string, fn func(e KeyEvent)) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	// Lines are read whole, however large the event.
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		var e KeyEvent
+		if len(line) > 0 && json.Unmarshal(line, &e) == nil {
+			fn(e)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Delete rewrites the files which hold events of the given sequence numbers.
+func (s *FileKeyEventStore) Delete(seqs []uint64) error {
+	deleted := make(map[uint64]bool, len(seqs))
+	for _, seq := range seqs {
+		deleted[seq] = true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errors.New("microbot: FileKeyEventStore closed")
+	}
+	for i := s.config.MaxFiles; i >= 1; i-- {
+		if err := rewriteKeyEvents(s.rotatedPath(i), deleted); err != nil {
+			return err
+		}
+	}
+
+	if s.file != nil {
+		if err := s.file.Close(); err != nil {
+			return err
+		}
+		s.file = nil
+	}
+	err := rewriteKeyEvents(s.config.Path, deleted)
+	if oerr := s.open(); err == nil {
+		err = oerr
+	}
+	return err
+}
+
+// rewriteKeyEvents removes the deleted events from the file at path, through
+// a temporary file renamed over it. The file is left as is if it holds none.
+func rewriteKeyEvents(path string, deleted map[uint64]bool) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var kept bytes.Buffer
+	changed := false
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		var e KeyEvent
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		if json.Unmarshal(line, &e) == nil && deleted[e.Seq] {
+			changed = true
+			continue
+		}
+		kept.Write(line)
+	}
+	if !changed {
+		return nil
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, kept.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// LastSeq reads the newest file holding events.
+func (s *FileKeyEventStore) LastSeq() (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i <= s.config.MaxFiles; i++ {
+		var seq uint64
+		err := scanKeyEvents(s.path(i), func(e KeyEvent) {
+			if e.Seq > seq {
+				seq = e.Seq
+			}
+		})
+		if err != nil || seq != 0 {
+			return seq, err
+		}
+	}
+	return 0, nil
+}
+
+func (s *FileKeyEventStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
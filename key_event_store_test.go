@@ -0,0 +1,197 @@
+package microbot
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pangpanglabs/microbot/db"
+)
+
+// seqs returns the sequence numbers of events.
+func seqs(events []KeyEvent) []uint64 {
+	s := []uint64{}
+	for _, e := range events {
+		s = append(s, e.Seq)
+	}
+	return s
+}
+
+// testKeyEventStore appends 10 events to s, of types a and b in turn, and
+// checks loading and paging them.
+func testKeyEventStore(t *testing.T, s KeyEventStore) {
+	t.Helper()
+	base := time.Unix(1700000000, 0)
+	for i := 1; i <= 10; i++ {
+		typ := "a"
+		if i%2 == 0 {
+			typ = "b"
+		}
+		e := KeyEvent{
+			Seq:      uint64(i),
+			Type:     typ,
+			Content:  fmt.Sprintf("Event %d", i),
+			Time:     base.Add(time.Duration(i) * time.Second),
+			Severity: SeverityInfo,
+		}
+		if i == 10 {
+			e.Content = ""
+			e.Attributes = map[string]string{"k": "v"}
+		}
+		if err := s.Append(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if seq, err := s.LastSeq(); err != nil || seq != 10 {
+		t.Errorf("LastSeq = %d, %v, want 10", seq, err)
+	}
+	events, err := s.Load(KeyEventFilter{Types: []string{"b"}, Since: base.Add(5 * time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := seqs(events); !reflect.DeepEqual(got, []uint64{6, 8, 10}) {
+		t.Errorf("Load = %v, want [6 8 10]", got)
+	}
+	if e := events[2]; e.Content != "" || e.Attributes["k"] != "v" {
+		t.Errorf("event 10 = %+v", e)
+	}
+
+	querier := s.(KeyEventQuerier)
+	tests := []struct {
+		name   string
+		q      KeyEventQuery
+		total  int
+		want   []uint64
+		cursor uint64
+	}{
+		{"desc", KeyEventQuery{Limit: 3}, 10, []uint64{10, 9, 8}, 8},
+		{"desc cursor", KeyEventQuery{Limit: 3, Cursor: 8}, 10, []uint64{7, 6, 5}, 5},
+		{"desc offset", KeyEventQuery{Limit: 3, Offset: 8}, 10, []uint64{2, 1}, 0},
+		{"asc", KeyEventQuery{Limit: 4, Asc: true, Cursor: 6}, 10, []uint64{7, 8, 9, 10}, 0},
+		{"type", KeyEventQuery{KeyEventFilter: KeyEventFilter{Types: []string{"a"}}, Limit: 2}, 5, []uint64{9, 7}, 7},
+		{"search", KeyEventQuery{KeyEventFilter: KeyEventFilter{Search: "EVENT 1"}, Limit: 5}, 1, []uint64{1}, 0},
+		{"severity", KeyEventQuery{KeyEventFilter: KeyEventFilter{Severities: []Severity{SeverityError}}, Limit: 5}, 0, []uint64{}, 0},
+	}
+	for _, tt := range tests {
+		page, err := querier.Query(tt.q)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if page.Total != tt.total || !reflect.DeepEqual(seqs(page.Events), tt.want) || page.NextCursor != tt.cursor {
+			t.Errorf("%s: page = %d %v %d, want %d %v %d", tt.name,
+				page.Total, seqs(page.Events), page.NextCursor, tt.total, tt.want, tt.cursor)
+		}
+		// Paging in the store gives the pages of KeyEventQuery.Page.
+		all, _ := s.Load(KeyEventFilter{})
+		if want := tt.q.Page(all); !reflect.DeepEqual(seqs(want.Events), tt.want) || want.Total != tt.total {
+			t.Errorf("%s: Page = %d %v", tt.name, want.Total, seqs(want.Events))
+		}
+	}
+}
+
+func TestFileKeyEventStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+	// Rotates every few events, all 10 still fit in the kept files.
+	s, err := NewFileKeyEventStore(FileKeyEventStoreConfig{Path: path, MaxSize: 400, MaxFiles: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	testKeyEventStore(t, s)
+	if matches, _ := filepath.Glob(path + ".*"); len(matches) == 0 {
+		t.Error("file was not rotated")
+	}
+
+	// A new store continues after the events of the files.
+	s.Close()
+	s, err = NewFileKeyEventStore(FileKeyEventStoreConfig{Path: path, MaxSize: 400, MaxFiles: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq, err := s.LastSeq(); err != nil || seq != 10 {
+		t.Errorf("LastSeq after reopening = %d, %v, want 10", seq, err)
+	}
+}
+
+// TestFileKeyEventStoreLines checks that events larger than any buffer are
+// read back, and that lines which fail to decode are skipped.
+func TestFileKeyEventStoreLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+	s, err := NewFileKeyEventStore(FileKeyEventStoreConfig{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	large := strings.Repeat("x", 2<<20)
+	if err := s.Append(KeyEvent{Seq: 1, Type: "large", Content: large}); err != nil {
+		t.Fatal(err)
+	}
+	s.file.WriteString("{\"Seq\": 2, cut by a crash\n")
+	if err := s.Append(KeyEvent{Seq: 3, Type: "small"}); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := s.Load(KeyEventFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := seqs(events); !reflect.DeepEqual(got, []uint64{1, 3}) {
+		t.Fatalf("Load = %v, want [1 3]", got)
+	}
+	if events[0].Content != large {
+		t.Errorf("large content of %d bytes read back as %d bytes", len(large), len(events[0].Content))
+	}
+	if page, err := s.Query(KeyEventQuery{Limit: 10}); err != nil || page.Total != 2 {
+		t.Errorf("Query = %d events, %v, want 2", page.Total, err)
+	}
+	if seq, err := s.LastSeq(); err != nil || seq != 3 {
+		t.Errorf("LastSeq = %d, %v, want 3", seq, err)
+	}
+}
+
+func TestSQLKeyEventStore(t *testing.T) {
+	sqlDB, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlDB.Close()
+	// Every connection to :memory: opens a database of its own.
+	sqlDB.SetMaxOpenConns(1)
+
+	s := &SQLKeyEventStore{
+		db:      sqlDB,
+		dbType:  db.SQLITE,
+		table:   DefaultSQLKeyEventStoreConfig.Table,
+		columns: strings.Join(keyEventColumns, ", "),
+	}
+	ddl, _ := s.createTable()
+	if _, err := sqlDB.Exec(ddl); err != nil {
+		t.Fatal(err)
+	}
+	testKeyEventStore(t, s)
+}
+
+func TestSetStoreBuffers(t *testing.T) {
+	resetKeyEvents(t)
+
+	s, err := NewFileKeyEventStore(FileKeyEventStoreConfig{Path: filepath.Join(t.TempDir(), "events.log")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := (KeyEventList{}).SetStore(s); err != nil {
+		t.Fatal(err)
+	}
+	queueLock.RLock()
+	buffered := keyEventQueue != nil && cap(keyEventQueue) == DefaultStoreBuffer
+	queueLock.RUnlock()
+	if !buffered {
+		t.Error("SetStore did not buffer a file store")
+	}
+}
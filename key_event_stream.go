@@ -0,0 +1,287 @@
+package microbot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pangpanglabs/microbot/utils"
+)
+
+// keyEventWriteWait is the time allowed to write a message to a WebSocket.
+const keyEventWriteWait = 10 * time.Second
+
+type (
+	// KeyEventStreamConfig defines the config for KeyEventStreamController.
+	KeyEventStreamConfig struct {
+		// BufferSize is the number of events buffered per subscriber. Events
+		// recorded while the buffer is full are dropped for that subscriber.
+		// Optional. Default value 256.
+		BufferSize int `yaml:"buffer_size"`
+
+		// Heartbeat is the interval of pings sent to keep idle streams open.
+		// Optional. Default value 15 seconds.
+		Heartbeat time.Duration `yaml:"heartbeat"`
+
+		// EnableWebSocket serves WebSocket upgrade requests, besides
+		// Server-Sent Events.
+		// Optional. Default value false.
+		EnableWebSocket bool `yaml:"enable_websocket"`
+
+		// CheckOrigin of WebSocket upgrade requests, see
+		// websocket.Upgrader.
+		// Optional. Default value nil, which rejects cross-origin requests.
+		CheckOrigin func(r *http.Request) bool `yaml:"-"`
+	}
+
+	// keyEventSubscriber receives the recorded events matching its filter.
+	keyEventSubscriber struct {
+		filter KeyEventFilter
+		events chan KeyEvent
+		// done is closed when streams are closed by the shutdown.
+		done chan struct{}
+	}
+)
+
+var (
+	// DefaultKeyEventStreamConfig is the default KeyEventStreamController
+	// config.
+	DefaultKeyEventStreamConfig = KeyEventStreamConfig{
+		BufferSize: 256,
+		Heartbeat:  15 * time.Second,
+	}
+
+	subscribersMu     sync.RWMutex
+	subscribers       = make(map[*keyEventSubscriber]struct{})
+	subscribersClosed bool
+)
+
+func KeyEventStreamController() http.Handler {
+	return KeyEventStreamControllerWithConfig(DefaultKeyEventStreamConfig)
+}
+
+// KeyEventStreamControllerWithConfig streams key events as they are recorded,
+// with Server-Sent Events. It accepts the filters of KeyEventController, and
+// resumes after the sequence number of the Last-Event-ID header, or of the
+// lastEventId parameter, replaying the events still in the store.
+func KeyEventStreamControllerWithConfig(config KeyEventStreamConfig) http.Handler {
+	// Defaults
+	if config.BufferSize == 0 {
+		config.BufferSize = DefaultKeyEventStreamConfig.BufferSize
+	}
+	if config.Heartbeat == 0 {
+		config.Heartbeat = DefaultKeyEventStreamConfig.Heartbeat
+	}
+	upgrader := websocket.Upgrader{CheckOrigin: config.CheckOrigin}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q, err := ParseKeyEventQuery(r)
+		if err != nil {
+			utils.RenderErrorJson(w, err)
+			return
+		}
+		lastID := r.Header.Get("Last-Event-ID")
+		if lastID == "" {
+			lastID = r.URL.Query().Get("lastEventId")
+		}
+		var last uint64
+		if lastID != "" {
+			if last, err = strconv.ParseUint(lastID, 10, 64); err != nil {
+				utils.RenderErrorJson(w, errors.New("microbot: invalid Last-Event-ID"))
+				return
+			}
+		}
+
+		startKeyEvents()
+		sub := subscribeKeyEvents(q.KeyEventFilter, config.BufferSize)
+		defer sub.unsubscribe()
+		// The store is read after subscribing so that no event is missed in
+		// between, events received twice are skipped by sequence number.
+		var backlog []KeyEvent
+		if lastID != "" {
+			if backlog, err = loadKeyEventsAfter(currentKeyEventStore(), q.KeyEventFilter, last); err != nil {
+				utils.RenderErrorJson(w, err)
+				return
+			}
+		}
+
+		if config.EnableWebSocket && websocket.IsWebSocketUpgrade(r) {
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				// Upgrade has replied with the error.
+				return
+			}
+			streamKeyEventsWebSocket(conn, sub, backlog, last, config.Heartbeat)
+			return
+		}
+		streamKeyEventsSSE(w, r, sub, backlog, last, config.Heartbeat)
+	})
+}
+
+func streamKeyEventsSSE(w http.ResponseWriter, r *http.Request, sub *keyEventSubscriber, backlog []KeyEvent, last uint64, heartbeat time.Duration) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	// Disables the response buffering of nginx.
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(e KeyEvent) error {
+		if e.Seq <= last {
+			return nil
+		}
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "id: %d\ndata: %s\n\n", e.Seq, data); err != nil {
+			return err
+		}
+		last = e.Seq
+		return nil
+	}
+	for _, e := range backlog {
+		if send(e) != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case e := <-sub.events:
+			if send(e) != nil {
+				return
+			}
+		case <-ticker.C:
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case <-sub.done:
+			return
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func streamKeyEventsWebSocket(conn *websocket.Conn, sub *keyEventSubscriber, backlog []KeyEvent, last uint64, heartbeat time.Duration) {
+	defer conn.Close()
+	// Messages of the client are discarded, reading only detects the close
+	// of the connection.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func(e KeyEvent) error {
+		if e.Seq <= last {
+			return nil
+		}
+		conn.SetWriteDeadline(time.Now().Add(keyEventWriteWait))
+		if err := conn.WriteJSON(e); err != nil {
+			return err
+		}
+		last = e.Seq
+		return nil
+	}
+	for _, e := range backlog {
+		if send(e) != nil {
+			return
+		}
+	}
+
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case e := <-sub.events:
+			if send(e) != nil {
+				return
+			}
+		case <-ticker.C:
+			if conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(keyEventWriteWait)) != nil {
+				return
+			}
+		case <-sub.done:
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+				time.Now().Add(keyEventWriteWait))
+			return
+		case <-closed:
+			return
+		}
+	}
+}
+
+func subscribeKeyEvents(filter KeyEventFilter, size int) *keyEventSubscriber {
+	sub := &keyEventSubscriber{
+		filter: filter,
+		events: make(chan KeyEvent, size),
+		done:   make(chan struct{}),
+	}
+	subscribersMu.Lock()
+	defer subscribersMu.Unlock()
+	if subscribersClosed {
+		close(sub.done)
+		return sub
+	}
+	subscribers[sub] = struct{}{}
+	return sub
+}
+
+func (sub *keyEventSubscriber) unsubscribe() {
+	subscribersMu.Lock()
+	defer subscribersMu.Unlock()
+	delete(subscribers, sub)
+}
+
+// publishKeyEvent sends e to the subscribers it matches, without blocking.
+func publishKeyEvent(e KeyEvent) {
+	subscribersMu.RLock()
+	defer subscribersMu.RUnlock()
+	for sub := range subscribers {
+		if !sub.filter.Match(e) {
+			continue
+		}
+		select {
+		case sub.events <- e:
+		default:
+			keyEventsDropped.Inc()
+		}
+	}
+}
+
+// closeKeyEventStreams ends the current streams and the ones opened later.
+func closeKeyEventStreams() {
+	subscribersMu.Lock()
+	defer subscribersMu.Unlock()
+	if subscribersClosed {
+		return
+	}
+	subscribersClosed = true
+	for sub := range subscribers {
+		close(sub.done)
+		delete(subscribers, sub)
+	}
+}
@@ -0,0 +1,173 @@
+package microbot
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func subscriberCount() int {
+	subscribersMu.RLock()
+	defer subscribersMu.RUnlock()
+	return len(subscribers)
+}
+
+// waitSubscribers waits until n streams are subscribed.
+func waitSubscribers(t *testing.T, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); subscriberCount() != n; {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d subscribers, want %d", subscriberCount(), n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// readSSE returns the id and the event of the next message of an SSE stream,
+// skipping heartbeats.
+func readSSE(t *testing.T, r *bufio.Reader) (string, KeyEvent) {
+	t.Helper()
+	var id string
+	var e KeyEvent
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e); err != nil {
+				t.Fatal(err)
+			}
+		case line == "" && id != "":
+			return id, e
+		}
+	}
+}
+
+// streamedServer serves KeyEventStreamController, signalling on done each
+// time the handler returns.
+func streamedServer(t *testing.T, config KeyEventStreamConfig) (*httptest.Server, <-chan struct{}) {
+	done := make(chan struct{}, 1)
+	h := KeyEventStreamControllerWithConfig(config)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() { done <- struct{}{} }()
+		h.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, done
+}
+
+func TestKeyEventStreamSSEResume(t *testing.T) {
+	resetKeyEvents(t)
+	var l KeyEventList
+	for i := 1; i <= 3; i++ {
+		l.New("streamed", strconv.Itoa(i))
+	}
+	l.New("other", "")
+	events := loadKeyEvents(t, "streamed")
+	srv, done := streamedServer(t, KeyEventStreamConfig{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"?type=streamed", nil)
+	req.Header.Set("Last-Event-ID", strconv.FormatUint(events[0].Seq, 10))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	r := bufio.NewReader(resp.Body)
+
+	// The events after Last-Event-ID are replayed, then the new ones follow.
+	for _, want := range events[1:] {
+		if id, e := readSSE(t, r); id != strconv.FormatUint(want.Seq, 10) || e.Content != want.Content {
+			t.Errorf("replayed %s %q, want %d %q", id, e.Content, want.Seq, want.Content)
+		}
+	}
+	waitSubscribers(t, 1)
+	l.New("other", "")
+	l.New("streamed", "4")
+	if _, e := readSSE(t, r); e.Content != "4" {
+		t.Errorf("streamed %q, want 4", e.Content)
+	}
+
+	// The client going away ends the handler and its subscription.
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler still running after the client went away")
+	}
+	waitSubscribers(t, 0)
+}
+
+func TestKeyEventStreamWebSocket(t *testing.T) {
+	resetKeyEvents(t)
+	var l KeyEventList
+	l.New("streamed", "1")
+	l.New("streamed", "2")
+	first := loadKeyEvents(t, "streamed")[0]
+	srv, done := streamedServer(t, KeyEventStreamConfig{EnableWebSocket: true})
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "?type=streamed&lastEventId=" + strconv.FormatUint(first.Seq, 10)
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var e KeyEvent
+	if err := conn.ReadJSON(&e); err != nil || e.Content != "2" {
+		t.Fatalf("replayed %q, %v, want 2", e.Content, err)
+	}
+	waitSubscribers(t, 1)
+	l.New("streamed", "3")
+	if err := conn.ReadJSON(&e); err != nil || e.Content != "3" {
+		t.Fatalf("streamed %q, %v, want 3", e.Content, err)
+	}
+
+	conn.Close()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler still running after the client closed the connection")
+	}
+	waitSubscribers(t, 0)
+}
+
+func TestKeyEventStreamSlowSubscriber(t *testing.T) {
+	resetKeyEvents(t)
+	sub := subscribeKeyEvents(KeyEventFilter{Types: []string{"flooded"}}, 2)
+	defer sub.unsubscribe()
+	dropped := testutil.ToFloat64(keyEventsDropped)
+
+	// Recording never waits for a subscriber which does not read.
+	var l KeyEventList
+	for i := 0; i < 5; i++ {
+		l.New("flooded", strconv.Itoa(i))
+	}
+	if n := testutil.ToFloat64(keyEventsDropped) - dropped; n != 3 {
+		t.Errorf("dropped %v events, want 3", n)
+	}
+	if e := <-sub.events; e.Content != "0" {
+		t.Errorf("kept %q first, want 0", e.Content)
+	}
+	if e := <-sub.events; e.Content != "1" {
+		t.Errorf("kept %q second, want 1", e.Content)
+	}
+}
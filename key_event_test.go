@@ -0,0 +1,175 @@
+package microbot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// resetKeyEvents empties the in-memory store, and restores the default
+// store, length and buffer of key events when the test ends.
+func resetKeyEvents(t *testing.T) {
+	keyEvents.mu.Lock()
+	keyEvents.start, keyEvents.size = 0, 0
+	keyEvents.mu.Unlock()
+	queueLock.Lock()
+	bufferSet = false
+	queueLock.Unlock()
+	t.Cleanup(func() {
+		var l KeyEventList
+		l.SetBuffer(0)
+		l.SetLength(DefaultListMax)
+		l.SetStore(keyEvents)
+		queueLock.Lock()
+		bufferSet = false
+		queueLock.Unlock()
+	})
+}
+
+// loadKeyEvents returns the events of type t in the in-memory store.
+func loadKeyEvents(t *testing.T, typ string) []KeyEvent {
+	t.Helper()
+	events, err := keyEvents.Load(KeyEventFilter{Types: []string{typ}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func TestKeyEventsConcurrent(t *testing.T) {
+	resetKeyEvents(t)
+	var l KeyEventList
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(4)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				l.New("concurrent", fmt.Sprint(j))
+			}
+		}()
+		go func(i int) {
+			defer wg.Done()
+			l.SetBuffer(i % 3)
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			l.SetLength(10 + i)
+		}(i)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			KeyEventController().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?type=concurrent", nil))
+			if w.Code != http.StatusOK {
+				t.Errorf("status = %d", w.Code)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := drainKeyEvents(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(loadKeyEvents(t, "concurrent")); n == 0 || n > 17 {
+		t.Errorf("kept %d events, want between 1 and the length", n)
+	}
+}
+
+func TestKeyEventsSequenceOrder(t *testing.T) {
+	resetKeyEvents(t)
+	var l KeyEventList
+	l.SetBuffer(16)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				l.New("ordered", "")
+			}
+		}()
+	}
+	wg.Wait()
+	if err := drainKeyEvents(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	events := loadKeyEvents(t, "ordered")
+	if len(events) != 200 {
+		t.Fatalf("kept %d events, want 200", len(events))
+	}
+	for i := 1; i < len(events); i++ {
+		if events[i].Seq <= events[i-1].Seq {
+			t.Fatalf("seq %d follows %d", events[i].Seq, events[i-1].Seq)
+		}
+	}
+	last, _ := keyEvents.LastSeq()
+	if last != events[len(events)-1].Seq {
+		t.Errorf("LastSeq = %d, want %d", last, events[len(events)-1].Seq)
+	}
+}
+
+func TestKeyEventsEviction(t *testing.T) {
+	resetKeyEvents(t)
+	var l KeyEventList
+	evicted := keyEventsEvicted.WithLabelValues("evicted", evictedLength)
+	before := testutil.ToFloat64(evicted)
+	l.SetLength(3)
+	for i := 0; i < 5; i++ {
+		l.New("evicted", fmt.Sprint(i))
+	}
+
+	events := loadKeyEvents(t, "evicted")
+	var got []string
+	for _, e := range events {
+		got = append(got, e.Content)
+	}
+	if fmt.Sprint(got) != "[2 3 4]" {
+		t.Errorf("kept %v, want the 3 newest", got)
+	}
+	if n := testutil.ToFloat64(evicted) - before; n != 2 {
+		t.Errorf("evicted = %v, want 2", n)
+	}
+
+	l.SetLength(1)
+	if n := testutil.ToFloat64(evicted) - before; n != 4 {
+		t.Errorf("evicted after shrinking = %v, want 4", n)
+	}
+}
+
+func TestKeyEventController(t *testing.T) {
+	resetKeyEvents(t)
+	var l KeyEventList
+	for i := 0; i < 5; i++ {
+		l.Record("paged", fmt.Sprint(i), WithSeverity(SeverityWarning))
+	}
+
+	var resp struct {
+		Result KeyEventPage `json:"result"`
+	}
+	get := func(query string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		KeyEventController().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?type=paged&limit=2"+query, nil))
+		resp.Result = KeyEventPage{}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	get("")
+	if resp.Result.Total != 5 || len(resp.Result.Events) != 2 || resp.Result.Events[0].Content != "4" {
+		t.Fatalf("first page = %+v", resp.Result)
+	}
+	get(fmt.Sprintf("&cursor=%d", resp.Result.NextCursor))
+	if len(resp.Result.Events) != 2 || resp.Result.Events[0].Content != "2" {
+		t.Fatalf("second page = %+v", resp.Result)
+	}
+	if e := resp.Result.Events[0]; e.Severity != SeverityWarning || e.Source == "" {
+		t.Errorf("event = %+v, want severity and source", e)
+	}
+}
@@ -0,0 +1,204 @@
+package microbot
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type (
+	// ShutdownConfig defines the config for ShutdownServerWithConfig.
+	ShutdownConfig struct {
+		// DrainDelay between marking the service unready and shutting the
+		// server down, for readiness probes and load balancers to see the
+		// 503 of ReadinessController and stop sending requests. A negative
+		// value shuts the server down right away.
+		// Optional. Default value 5 seconds.
+		DrainDelay time.Duration `yaml:"drain_delay"`
+
+		// CleanupTimeout bounds the shutdown of microbot once the server is
+		// shut down. It has a context of its own, so that key events, alerts,
+		// pushers and spans are still flushed when the context of
+		// ShutdownServer ends while waiting for requests.
+		// Optional. Default value 5 seconds.
+		CleanupTimeout time.Duration `yaml:"cleanup_timeout"`
+	}
+)
+
+var (
+	// DefaultShutdownConfig is the default ShutdownServer config.
+	DefaultShutdownConfig = ShutdownConfig{
+		DrainDelay:     5 * time.Second,
+		CleanupTimeout: 5 * time.Second,
+	}
+
+	// inFlight is the number of requests currently served by the middlewares.
+	inFlight atomic.Int64
+
+	unready atomic.Bool
+
+	pushersMu sync.Mutex
+	pushers   []*Pusher
+
+	shutdownOnce sync.Once
+	shutdownErr  error
+)
+
+// ReadinessController answers 200 while the service is ready, and 503 once
+// ShutdownServer has been called.
+func ReadinessController() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if unready.Load() {
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+}
+
+func ShutdownServer(ctx context.Context, srv *http.Server) error {
+	return ShutdownServerWithConfig(ctx, srv, DefaultShutdownConfig)
+}
+
+// ShutdownServerWithConfig gracefully stops srv. It marks the service unready
+// and waits for DrainDelay, ends the key event streams, shuts srv down so that
+// it stops accepting connections, waits for the requests in flight in the
+// middlewares to finish, e.g. on hijacked connections, and then shuts
+// microbot down within CleanupTimeout. If ctx ends before the requests, srv
+// is closed and microbot is still shut down.
+func ShutdownServerWithConfig(ctx context.Context, srv *http.Server, config ShutdownConfig) error {
+	// Defaults
+	if config.DrainDelay == 0 {
+		config.DrainDelay = DefaultShutdownConfig.DrainDelay
+	}
+	if config.CleanupTimeout == 0 {
+		config.CleanupTimeout = DefaultShutdownConfig.CleanupTimeout
+	}
+
+	unready.Store(true)
+	if config.DrainDelay > 0 {
+		timer := time.NewTimer(config.DrainDelay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+		}
+	}
+	closeKeyEventStreams()
+	var errs []error
+	err := srv.Shutdown(ctx)
+	if err == nil {
+		err = waitInFlight(ctx)
+	}
+	if err != nil {
+		errs = append(errs, err)
+		srv.Close()
+	}
+	cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), config.CleanupTimeout)
+	defer cancel()
+	if err := Shutdown(cleanupCtx); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// waitInFlight waits until no request is in flight in the middlewares.
+func waitInFlight(ctx context.Context) error {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for inFlight.Load() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Shutdown stops the DB prober, drains pending key events, ends the key event
+// streams, stops the compactor, sends the queued alerts, closes the key event
+// store, flushes pushers and exporters, and unregisters the collectors of
+// microbot. Calls after the first one return the same result. Every step is
+// bounded by ctx, which must have time left for them, e.g. not be the context
+// an http.Server shutdown already timed out on.
+func Shutdown(ctx context.Context) error {
+	shutdownOnce.Do(func() {
+		shutdownErr = shutdown(ctx)
+	})
+	return shutdownErr
+}
+
+func shutdown(ctx context.Context) error {
+	var errs []error
+	close(proberStop)
+	select {
+	case <-proberDone:
+	case <-ctx.Done():
+		errs = append(errs, ctx.Err())
+	}
+
+	if err := drainKeyEvents(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	closeKeyEventStreams()
+	if err := stopCompactor(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	if err := closeAlerters(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	if err := currentKeyEventStore().Close(); err != nil {
+		errs = append(errs, err)
+	}
+
+	pushersMu.Lock()
+	for _, p := range pushers {
+		if err := p.Stop(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	pushersMu.Unlock()
+
+	if err := FlushSpans(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	spans.mu.RLock()
+	exporter := spans.exporter
+	spans.mu.RUnlock()
+	if exporter != nil {
+		if err := exporter.Shutdown(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// Sinks may remove themselves when closed, so they are closed outside
+	// of eachSink.
+	var closers []interface{ Close() error }
+	eachSink(func(sk sink) {
+		if c, ok := sk.(interface{ Close() error }); ok {
+			closers = append(closers, c)
+		}
+	})
+	for _, c := range closers {
+		if err := c.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for _, c := range collectors {
+		prometheus.Unregister(c)
+	}
+	return errors.Join(errs...)
+}
+
+func registerPusher(p *Pusher) {
+	pushersMu.Lock()
+	defer pushersMu.Unlock()
+	pushers = append(pushers, p)
+}
@@ -0,0 +1,165 @@
+package microbot
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TestShutdownServer runs each case in a process of its own, as Shutdown
+// ends the process-wide state of microbot.
+func TestShutdownServer(t *testing.T) {
+	for _, mode := range []string{"graceful", "timeout"} {
+		t.Run(mode, func(t *testing.T) {
+			cmd := exec.Command(os.Args[0], "-test.run=^TestShutdownServerProcess$", "-test.v")
+			cmd.Env = append(os.Environ(), "MICROBOT_SHUTDOWN_TEST="+mode)
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Fatalf("%v\n%s", err, out)
+			}
+		})
+	}
+}
+
+func TestShutdownServerProcess(t *testing.T) {
+	mode := os.Getenv("MICROBOT_SHUTDOWN_TEST")
+	if mode == "" {
+		t.Skip("run by TestShutdownServer")
+	}
+
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.Handle("/ready", ReadinessController())
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		if mode == "graceful" {
+			time.Sleep(100 * time.Millisecond)
+		} else {
+			<-release
+		}
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: MiddlewareWithConfig(nil, quietConfig)(mux)}
+	go srv.Serve(ln)
+	url := "http://" + ln.Addr().String()
+
+	// The final push runs after the server, even when it timed out.
+	var pushes atomic.Int32
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pushes.Add(1)
+	}))
+	defer gateway.Close()
+	p, err := NewPusher(PusherConfig{URL: gateway.URL, Job: "shutdown", Interval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Start()
+
+	result := make(chan error, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err == nil {
+			resp.Body.Close()
+		}
+		result <- err
+	}()
+	<-started
+
+	timeout := 5 * time.Second
+	if mode == "timeout" {
+		timeout = 500 * time.Millisecond
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- ShutdownServerWithConfig(ctx, srv, ShutdownConfig{DrainDelay: 300 * time.Millisecond})
+	}()
+
+	// Probes see the service unready while it drains.
+	ready := http.StatusOK
+	for deadline := time.Now().Add(250 * time.Millisecond); ready != http.StatusServiceUnavailable && time.Now().Before(deadline); {
+		resp, err := http.Get(url + "/ready")
+		if err != nil {
+			t.Fatalf("readiness probe failed while draining: %v", err)
+		}
+		resp.Body.Close()
+		ready = resp.StatusCode
+	}
+	if ready != http.StatusServiceUnavailable {
+		t.Errorf("readiness = %d while draining, want 503", ready)
+	}
+	err = <-done
+
+	if _, derr := http.Get(url); derr == nil {
+		t.Error("server still accepts connections")
+	}
+	if prometheus.Register(requests) != nil {
+		t.Error("microbot was not shut down, its collectors are registered")
+	}
+	if pushes.Load() == 0 {
+		t.Error("no final push")
+	}
+
+	switch mode {
+	case "graceful":
+		if err != nil {
+			t.Errorf("ShutdownServer: %v", err)
+		}
+		if err := <-result; err != nil {
+			t.Errorf("request in flight failed: %v", err)
+		}
+	case "timeout":
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("ShutdownServer = %v, want deadline exceeded", err)
+		}
+		if err := <-result; err == nil {
+			t.Error("request in flight was not aborted")
+		}
+	}
+}
+
+func TestDrainKeyEventsWhileRecording(t *testing.T) {
+	var l KeyEventList
+	l.SetBuffer(4)
+	defer l.SetBuffer(0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				l.New("draining", "")
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		if err := drainKeyEvents(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+	if err := drainKeyEvents(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	if pendingKeyEvents != 0 {
+		t.Errorf("%d events pending after drain", pendingKeyEvents)
+	}
+}
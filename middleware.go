@@ -1,9 +1,8 @@
 package microbot
 
 import (
-	"fmt"
+	"log/slog"
 	"net/http"
-	"runtime"
 	"time"
 
 	"github.com/pangpanglabs/microbot/utils"
@@ -30,6 +29,33 @@ type (
 		// DisablePrintStack disables printing stack trace.
 		// Optional. Default value as false.
 		DisablePrintStack bool `yaml:"disable_print_stack"`
+
+		// AccessLog enables access logs of requests.
+		// Optional. Default value nil, which disables access logs.
+		AccessLog *AccessLogConfig `yaml:"access_log"`
+
+		// PanicHandler writes the response after a panic is recovered.
+		// Optional. Default value DefaultPanicHandler, or the HTTPErrorHandler
+		// of echo in MiddlewareEcho.
+		PanicHandler PanicHandler `yaml:"-"`
+
+		// Logger receives recovered panics.
+		// Optional. Default value slog.Default().
+		Logger *slog.Logger `yaml:"-"`
+
+		// DisableTracing disables starting a span for each request, which
+		// continues the trace of the W3C traceparent header of the request.
+		// Optional. Default value false.
+		DisableTracing bool `yaml:"disable_tracing"`
+
+		// ExemplarExtractor returns the exemplar labels, e.g. trace_id and
+		// span_id, attached to the metrics of a request. Returning nil
+		// attaches no exemplar.
+		// Optional. Default value takes the IDs from the span of the request
+		// if it is sampled, which needs a sampled traceparent header or a
+		// span exporter, or from a sampled traceparent header when tracing
+		// is disabled.
+		ExemplarExtractor func(r *http.Request) prometheus.Labels `yaml:"-"`
 	}
 )
 
@@ -50,6 +76,12 @@ func DefaultSkipper(w http.ResponseWriter, r *http.Request) bool {
 
 // Middleware returns a middleware which recovers from panics anywhere in the chain
 // and handles the control to the centralized HTTPErrorHandler.
+//
+// The route label is the pattern matched by http.ServeMux, read after the
+// handler runs, falling back to handler if the request matched no pattern.
+// handler may be nil for services routed by http.ServeMux only. The request
+// duration is labeled with the route too, where earlier versions used the
+// request URI, which made a series of each URL.
 func Middleware(handler func(r *http.Request) string) func(h http.Handler) http.Handler {
 	return MiddlewareWithConfig(handler, DefaultMiddlewareConfig)
 }
@@ -64,6 +96,19 @@ func MiddlewareWithConfig(handler func(r *http.Request) string, config Middlewar
 	if config.StackSize == 0 {
 		config.StackSize = DefaultMiddlewareConfig.StackSize
 	}
+	if config.PanicHandler == nil {
+		config.PanicHandler = DefaultPanicHandler
+	}
+	rec := newRecorder(config)
+	route := func(r *http.Request) string {
+		if p := requestPattern(r); p != "" {
+			return p
+		}
+		if handler != nil {
+			return handler(r)
+		}
+		return ""
+	}
 
 	return func(h http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -72,45 +117,23 @@ func MiddlewareWithConfig(handler func(r *http.Request) string, config Middlewar
 				return
 			}
 
-			sw := StatusWriter{ResponseWriter: w}
-			defer func(begun time.Time) {
+			r = rec.start(r)
+			sw := StatusWriter{ResponseWriter: w, begun: time.Now()}
+			var errTypes []string
+			defer func() {
 				var path string
 				if sw.status != http.StatusNotFound {
-					path = handler(r)
-				}
-				if path == "/metrics" {
-					return
+					path = route(r)
 				}
-				s := fmt.Sprintf("%d", sw.status)
-				d := time.Since(begun).Nanoseconds() / int64(time.Millisecond)
-				ipType := "private"
-				if utils.IsPublicIP(utils.RealIP(r)) {
-					ipType = "public"
-				}
-
-				duration.WithLabelValues(r.RequestURI, s, r.Method, ipType).Observe(float64(d))
-				requests.With(prometheus.Labels{
-					"handler": path,
-					"status":  s,
-					"method":  r.Method,
-					"ip_type": ipType,
-				}).Inc()
-			}(time.Now())
+				rec.record(&sw, r, utils.RealIP(r), path, sw.status, errTypes)
+			}()
 
 			defer func() {
-				if r := recover(); r != nil {
-					err, ok := r.(error)
-					if !ok {
-						err = fmt.Errorf("%v", r)
-					}
-					stack := make([]byte, config.StackSize)
-					length := runtime.Stack(stack, !config.DisableStackAll)
-					if !config.DisablePrintStack {
-						fmt.Printf("[PANIC RECOVER] %v %s\n", err, stack[:length])
-					}
-					fmt.Print(err)
-
-					panics.Inc()
+				if v := recover(); v != nil {
+					errTypes = append(errTypes, "panic")
+					path := route(r)
+					err, stack := recoverPanic(r.Context(), config, v, r.Method, r.URL.Path, path)
+					config.PanicHandler(&sw, r, err, stack, path)
 				}
 			}()
 			h.ServeHTTP(&sw, r)
@@ -0,0 +1,25 @@
+package microbot
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func MiddlewareChi() func(h http.Handler) http.Handler {
+	return MiddlewareChiWithConfig(DefaultMiddlewareConfig)
+}
+
+// MiddlewareChiWithConfig returns a Middleware which takes the route label
+// from the route pattern matched by chi.
+func MiddlewareChiWithConfig(config MiddlewareConfig) func(h http.Handler) http.Handler {
+	return MiddlewareWithConfig(ChiRoute, config)
+}
+
+// ChiRoute returns the route pattern matched by chi for the request.
+func ChiRoute(r *http.Request) string {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		return rctx.RoutePattern()
+	}
+	return ""
+}
@@ -3,12 +3,9 @@ package microbot
 import (
 	"fmt"
 	"net/http"
-	"runtime"
 	"time"
 
 	"github.com/labstack/echo"
-	"github.com/pangpanglabs/microbot/utils"
-	"github.com/prometheus/client_golang/prometheus"
 )
 
 func MiddlewareEcho() echo.MiddlewareFunc {
@@ -16,51 +13,71 @@ func MiddlewareEcho() echo.MiddlewareFunc {
 }
 
 func MiddlewareEchoWithConfig(config MiddlewareConfig) echo.MiddlewareFunc {
+	// Defaults
+	if config.Skipper == nil {
+		config.Skipper = DefaultMiddlewareConfig.Skipper
+	}
 	if config.StackSize == 0 {
 		config.StackSize = DefaultMiddlewareConfig.StackSize
 	}
+	rec := newRecorder(config)
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
-		return func(c echo.Context) error {
-			defer func(begun time.Time) {
+		return func(c echo.Context) (err error) {
+			if config.Skipper(c.Response(), c.Request()) {
+				return next(c)
+			}
+
+			c.SetRequest(rec.start(c.Request()))
+			sw := &StatusWriter{ResponseWriter: c.Response().Writer, begun: time.Now()}
+			c.Response().Writer = sw
+			var panicked bool
+			defer func() {
+				// The error returned by the handler is written by echo's
+				// HTTPErrorHandler after the middleware chain returns.
+				status := c.Response().Status
+				if err != nil && !c.Response().Committed {
+					status = echoErrorStatus(err)
+				}
 				var path string
-				if c.Response().Status != http.StatusNotFound {
+				if status != http.StatusNotFound {
 					path = c.Path()
 				}
-				if path == "/metrics" {
-					return
+				var errTypes []string
+				switch {
+				case panicked:
+					errTypes = []string{"panic"}
+				case err != nil:
+					errTypes = []string{echoErrorType(err)}
 				}
-				s := fmt.Sprintf("%d", c.Response().Status)
-				d := time.Since(begun).Nanoseconds() / int64(time.Millisecond)
-				ipType := "private"
-				if utils.IsPublicIP(c.RealIP()) {
-					ipType = "public"
-				}
-
-				duration.WithLabelValues(c.Path(), s, c.Request().Method, ipType).Observe(float64(d))
-				requests.With(prometheus.Labels{
-					"handler": path,
-					"status":  s,
-					"method":  c.Request().Method,
-					"ip_type": ipType,
-				}).Inc()
-			}(time.Now())
+				rec.record(sw, c.Request(), c.RealIP(), path, status, errTypes)
+			}()
 
 			defer func() {
-				if r := recover(); r != nil {
-					err, ok := r.(error)
-					if !ok {
-						err = fmt.Errorf("%v", r)
+				if v := recover(); v != nil {
+					panicked = true
+					perr, stack := recoverPanic(c.Request().Context(), config, v, c.Request().Method, c.Request().URL.Path, c.Path())
+					if config.PanicHandler != nil {
+						config.PanicHandler(c.Response(), c.Request(), perr, stack, c.Path())
+					} else {
+						c.Error(perr)
 					}
-					stack := make([]byte, config.StackSize)
-					length := runtime.Stack(stack, !config.DisableStackAll)
-					if !config.DisablePrintStack {
-						fmt.Printf("[PANIC RECOVER] %v %s\n", err, stack[:length])
-					}
-					c.Error(err)
-					panics.Inc()
 				}
 			}()
 			return next(c)
 		}
 	}
 }
+
+func echoErrorStatus(err error) int {
+	if he, ok := err.(*echo.HTTPError); ok {
+		return he.Code
+	}
+	return http.StatusInternalServerError
+}
+
+func echoErrorType(err error) string {
+	if _, ok := err.(*echo.HTTPError); ok {
+		return "http"
+	}
+	return fmt.Sprintf("%T", err)
+}
@@ -0,0 +1,59 @@
+package microbot
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// TestMiddlewareEchoErrors checks that the errors returned by echo handlers
+// are recorded with the status echo answers.
+func TestMiddlewareEchoErrors(t *testing.T) {
+	e := echo.New()
+	e.Use(MiddlewareEchoWithConfig(quietConfig))
+	e.GET("/conflict/:id", func(c echo.Context) error {
+		return echo.NewHTTPError(http.StatusConflict)
+	})
+	e.GET("/failing", func(c echo.Context) error {
+		return errors.New("failing")
+	})
+
+	tests := []struct {
+		path    string
+		route   string
+		status  int
+		errType string
+	}{
+		{"/conflict/1", "/conflict/:id", http.StatusConflict, "http"},
+		// Unmatched requests have no route, like in the other middlewares.
+		{"/missing", "", http.StatusNotFound, "http"},
+		{"/failing", "/failing", http.StatusInternalServerError, "*errors.errorString"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			s := strconv.Itoa(tt.status)
+			total := requests.WithLabelValues(tt.route, s, http.MethodGet, "private")
+			errs := httpErrors.WithLabelValues(tt.route, s, tt.errType)
+			totalBefore, errsBefore := testutil.ToFloat64(total), testutil.ToFloat64(errs)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			r.RemoteAddr = "10.0.0.1:1234"
+			e.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			if n := testutil.ToFloat64(total) - totalBefore; n != 1 {
+				t.Errorf("requests of %q with status %s = %v, want 1", tt.route, s, n)
+			}
+			if n := testutil.ToFloat64(errs) - errsBefore; n != 1 {
+				t.Errorf("errors of %q of type %s = %v, want 1", tt.route, tt.errType, n)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package microbot
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ginWriter passes the writes of gin handlers through a StatusWriter.
+type ginWriter struct {
+	gin.ResponseWriter
+	sw *StatusWriter
+}
+
+func (w *ginWriter) WriteHeader(status int) {
+	// gin writes the header lazily, so it is not the first byte yet.
+	w.sw.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *ginWriter) WriteHeaderNow() {
+	w.sw.markFirstByte()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *ginWriter) Write(b []byte) (int, error) {
+	return w.sw.Write(b)
+}
+
+func (w *ginWriter) WriteString(s string) (int, error) {
+	return w.sw.Write([]byte(s))
+}
+
+func (w *ginWriter) Flush() {
+	w.sw.Flush()
+}
+
+func MiddlewareGin() gin.HandlerFunc {
+	return MiddlewareGinWithConfig(DefaultMiddlewareConfig)
+}
+
+// MiddlewareGinWithConfig returns a gin middleware with config, which takes
+// the route label from the route template matched by gin.
+func MiddlewareGinWithConfig(config MiddlewareConfig) gin.HandlerFunc {
+	// Defaults
+	if config.Skipper == nil {
+		config.Skipper = DefaultMiddlewareConfig.Skipper
+	}
+	if config.StackSize == 0 {
+		config.StackSize = DefaultMiddlewareConfig.StackSize
+	}
+	if config.PanicHandler == nil {
+		config.PanicHandler = DefaultPanicHandler
+	}
+	rec := newRecorder(config)
+	return func(c *gin.Context) {
+		if config.Skipper(c.Writer, c.Request) {
+			c.Next()
+			return
+		}
+
+		c.Request = rec.start(c.Request)
+		sw := &StatusWriter{ResponseWriter: c.Writer, begun: time.Now()}
+		c.Writer = &ginWriter{ResponseWriter: c.Writer, sw: sw}
+		var panicked bool
+		defer func() {
+			status := c.Writer.Status()
+			var path string
+			if status != http.StatusNotFound {
+				path = c.FullPath()
+			}
+			var errTypes []string
+			if panicked {
+				errTypes = append(errTypes, "panic")
+			}
+			for _, e := range c.Errors {
+				errTypes = append(errTypes, ginErrorType(e.Type))
+			}
+			rec.record(sw, c.Request, c.ClientIP(), path, status, errTypes)
+		}()
+
+		defer func() {
+			if v := recover(); v != nil {
+				panicked = true
+				err, stack := recoverPanic(c.Request.Context(), config, v, c.Request.Method, c.Request.URL.Path, c.FullPath())
+				config.PanicHandler(sw, c.Request, err, stack, c.FullPath())
+				c.Abort()
+			}
+		}()
+		c.Next()
+	}
+}
+
+func ginErrorType(t gin.ErrorType) string {
+	switch {
+	case t&gin.ErrorTypeBind != 0:
+		return "bind"
+	case t&gin.ErrorTypeRender != 0:
+		return "render"
+	case t&gin.ErrorTypePublic != 0:
+		return "public"
+	default:
+		return "private"
+	}
+}
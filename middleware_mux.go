@@ -0,0 +1,28 @@
+package microbot
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// MiddlewareMux returns a Middleware which takes the route label from the path
+// template matched by gorilla/mux. The route is only known inside the router,
+// so it must be added with Router.Use.
+func MiddlewareMux() mux.MiddlewareFunc {
+	return MiddlewareMuxWithConfig(DefaultMiddlewareConfig)
+}
+
+func MiddlewareMuxWithConfig(config MiddlewareConfig) mux.MiddlewareFunc {
+	return MiddlewareWithConfig(MuxRoute, config)
+}
+
+// MuxRoute returns the path template matched by gorilla/mux for the request.
+func MuxRoute(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if tpl, err := route.GetPathTemplate(); err == nil {
+			return tpl
+		}
+	}
+	return ""
+}
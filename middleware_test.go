@@ -0,0 +1,138 @@
+package microbot
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/mux"
+	"github.com/labstack/echo"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func panicking(w http.ResponseWriter, r *http.Request) {
+	panic("boom")
+}
+
+// TestMiddlewarePanicMetrics checks that every framework labels and counts a
+// recovered panic the same way.
+func TestMiddlewarePanicMetrics(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name    string
+		path    string
+		route   string
+		handler func(route string) http.Handler
+	}{
+		{"net/http", "/std/1", "/std/{id}", func(route string) http.Handler {
+			m := http.NewServeMux()
+			m.HandleFunc(route, panicking)
+			return MiddlewareWithConfig(nil, quietConfig)(m)
+		}},
+		{"chi", "/chi/1", "/chi/{id}", func(route string) http.Handler {
+			r := chi.NewRouter()
+			r.Use(MiddlewareChiWithConfig(quietConfig))
+			r.Get(route, panicking)
+			return r
+		}},
+		{"mux", "/mux/1", "/mux/{id}", func(route string) http.Handler {
+			r := mux.NewRouter()
+			r.Use(MiddlewareMuxWithConfig(quietConfig))
+			r.HandleFunc(route, panicking)
+			return r
+		}},
+		{"gin", "/gin/1", "/gin/:id", func(route string) http.Handler {
+			r := gin.New()
+			r.Use(MiddlewareGinWithConfig(quietConfig))
+			r.GET(route, func(c *gin.Context) { panic("boom") })
+			return r
+		}},
+		{"echo", "/echo/1", "/echo/:id", func(route string) http.Handler {
+			e := echo.New()
+			e.Use(MiddlewareEchoWithConfig(quietConfig))
+			e.GET(route, func(c echo.Context) error { panic("boom") })
+			return e
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := tt.handler(tt.route)
+			panics := httpErrors.WithLabelValues(tt.route, "500", "panic")
+			total := requests.WithLabelValues(tt.route, "500", http.MethodGet, "private")
+			panicsBefore, totalBefore := testutil.ToFloat64(panics), testutil.ToFloat64(total)
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			r.RemoteAddr = "10.0.0.1:1234"
+			h.ServeHTTP(w, r)
+			if w.Code != http.StatusInternalServerError {
+				t.Errorf("status = %d, want 500", w.Code)
+			}
+			if n := testutil.ToFloat64(panics) - panicsBefore; n != 1 {
+				t.Errorf("panic errors of %s = %v, want 1", tt.route, n)
+			}
+			if n := testutil.ToFloat64(total) - totalBefore; n != 1 {
+				t.Errorf("requests of %s = %v, want 1", tt.route, n)
+			}
+		})
+	}
+}
+
+// TestMiddlewareEmptyResponseStatus checks that a handler which writes
+// nothing is recorded with the 200 the client gets, as gin reports it.
+func TestMiddlewareEmptyResponseStatus(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	empty := func(w http.ResponseWriter, r *http.Request) {}
+
+	tests := []struct {
+		name    string
+		route   string
+		handler http.Handler
+	}{
+		{"net/http", "/std-empty", func() http.Handler {
+			m := http.NewServeMux()
+			m.HandleFunc("/std-empty", empty)
+			return MiddlewareWithConfig(nil, quietConfig)(m)
+		}()},
+		{"chi", "/chi-empty", func() http.Handler {
+			r := chi.NewRouter()
+			r.Use(MiddlewareChiWithConfig(quietConfig))
+			r.Get("/chi-empty", empty)
+			return r
+		}()},
+		{"mux", "/mux-empty", func() http.Handler {
+			r := mux.NewRouter()
+			r.Use(MiddlewareMuxWithConfig(quietConfig))
+			r.HandleFunc("/mux-empty", empty)
+			return r
+		}()},
+		{"gin", "/gin-empty", func() http.Handler {
+			r := gin.New()
+			r.Use(MiddlewareGinWithConfig(quietConfig))
+			r.GET("/gin-empty", func(c *gin.Context) {})
+			return r
+		}()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok := requests.WithLabelValues(tt.route, "200", http.MethodGet, "private")
+			zero := requests.WithLabelValues(tt.route, "0", http.MethodGet, "private")
+			okBefore, zeroBefore := testutil.ToFloat64(ok), testutil.ToFloat64(zero)
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, tt.route, nil)
+			r.RemoteAddr = "10.0.0.1:1234"
+			tt.handler.ServeHTTP(w, r)
+			if w.Code != http.StatusOK {
+				t.Errorf("status = %d, want 200", w.Code)
+			}
+			if n := testutil.ToFloat64(ok) - okBefore; n != 1 {
+				t.Errorf("requests of %s with status 200 = %v, want 1", tt.route, n)
+			}
+			if n := testutil.ToFloat64(zero) - zeroBefore; n != 0 {
+				t.Errorf("requests of %s with status 0 = %v, want 0", tt.route, n)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package microbot
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pangpanglabs/microbot/db"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// OTelConfig defines the config of the OpenTelemetry metrics bridge.
+type OTelConfig struct {
+	// MeterProvider creates the instruments.
+	// Required.
+	MeterProvider metric.MeterProvider
+
+	// DisablePrometheus stops recording the bridged metrics into the
+	// Prometheus registry, so that they are only emitted through OpenTelemetry.
+	// These are the HTTP request duration and total, the panics, the key
+	// events total and the DB accessibility. The other metrics, e.g. of gRPC
+	// and outgoing requests, have no OpenTelemetry counterpart and are still
+	// recorded.
+	// Optional. Default value false.
+	DisablePrometheus bool
+}
+
+type otelSink struct {
+	requestDuration metric.Float64Histogram
+	panics          metric.Int64Counter
+	keyEvents       metric.Int64Counter
+	dbAccessibility metric.Int64Counter
+}
+
+var (
+	otelMu     sync.Mutex
+	otelConfig *OTelConfig
+)
+
+// EnableOTel emits the HTTP, panic, key event and DB metrics of microbot
+// through an OpenTelemetry MeterProvider, named after the semantic
+// conventions where they exist. Calling it again with the same config does
+// nothing, with another config it fails.
+func EnableOTel(config OTelConfig) error {
+	if config.MeterProvider == nil {
+		return errors.New("microbot: nil MeterProvider")
+	}
+	otelMu.Lock()
+	defer otelMu.Unlock()
+	if otelConfig != nil {
+		if otelConfig.MeterProvider == config.MeterProvider && otelConfig.DisablePrometheus == config.DisablePrometheus {
+			return nil
+		}
+		return errors.New("microbot: OpenTelemetry is already enabled with another config")
+	}
+	meter := config.MeterProvider.Meter("github.com/pangpanglabs/microbot")
+
+	var s otelSink
+	var err error
+	if s.requestDuration, err = meter.Float64Histogram("http.server.request.duration",
+		metric.WithUnit("s"),
+		metric.WithDescription("Duration of HTTP server requests."),
+		metric.WithExplicitBucketBoundaries(0.005, 0.01, 0.025, 0.05, 0.075, 0.1, 0.25, 0.5, 0.75, 1, 2.5, 5, 7.5, 10),
+	); err != nil {
+		return err
+	}
+	if s.panics, err = meter.Int64Counter("microbot.panics",
+		metric.WithUnit("{panic}"),
+		metric.WithDescription("Number of recovered panics."),
+	); err != nil {
+		return err
+	}
+	if s.keyEvents, err = meter.Int64Counter("microbot.key_events",
+		metric.WithUnit("{event}"),
+		metric.WithDescription("Number of recorded key events."),
+	); err != nil {
+		return err
+	}
+	if s.dbAccessibility, err = meter.Int64Counter("microbot.db.accessibility",
+		metric.WithUnit("{ping}"),
+		metric.WithDescription("Number of DB pings by status."),
+	); err != nil {
+		return err
+	}
+	if _, err = meter.Int64ObservableUpDownCounter("db.client.connections.usage",
+		metric.WithUnit("{connection}"),
+		metric.WithDescription("Number of connections that are currently in the state described by the state attribute."),
+		metric.WithInt64Callback(observeDBConnections),
+	); err != nil {
+		return err
+	}
+
+	addSink(&s)
+	prometheusDisabled.Store(config.DisablePrometheus)
+	otelConfig = &config
+	return nil
+}
+
+func observeDBConnections(ctx context.Context, o metric.Int64Observer) error {
+	for _, d := range dialects {
+		stats := d.DB().Stats()
+		pool := attribute.String("db.client.connections.pool.name", string(d.DBType()))
+		o.Observe(int64(stats.Idle), metric.WithAttributes(pool,
+			attribute.String("db.client.connections.state", "idle")))
+		o.Observe(int64(stats.InUse), metric.WithAttributes(pool,
+			attribute.String("db.client.connections.state", "used")))
+	}
+	return nil
+}
+
+func (s *otelSink) recordRequest(method, route string, status int, elapsed time.Duration) {
+	attrs := []attribute.KeyValue{
+		attribute.String("http.request.method", method),
+		attribute.Int("http.response.status_code", status),
+	}
+	if route != "" {
+		attrs = append(attrs, attribute.String("http.route", route))
+	}
+	if status >= http.StatusInternalServerError {
+		attrs = append(attrs, attribute.String("error.type", http.StatusText(status)))
+	}
+	s.requestDuration.Record(context.Background(), elapsed.Seconds(), metric.WithAttributes(attrs...))
+}
+
+func (s *otelSink) recordPanic(route, fingerprint string) {
+	s.panics.Add(context.Background(), 1, metric.WithAttributes(
+		attribute.String("http.route", route),
+		attribute.String("microbot.panic.fingerprint", fingerprint),
+	))
+}
+
+func (s *otelSink) recordKeyEvent(t string) {
+	s.keyEvents.Add(context.Background(), 1, metric.WithAttributes(
+		attribute.String("microbot.key_event.type", t),
+	))
+}
+
+func (s *otelSink) recordDBPing(dbType db.DBType, err error, elapsed time.Duration) {
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	s.dbAccessibility.Add(context.Background(), 1, metric.WithAttributes(
+		attribute.String("db.system", string(dbType)),
+		attribute.String("status", status),
+	))
+}
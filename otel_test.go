@@ -0,0 +1,42 @@
+package microbot
+
+import (
+	"testing"
+
+	"go.opentelemetry.io/otel/metric/noop"
+)
+
+func TestEnableOTelIdempotent(t *testing.T) {
+	sinksMu.RLock()
+	n := len(sinks)
+	sinksMu.RUnlock()
+	defer func() {
+		otelMu.Lock()
+		otelConfig = nil
+		otelMu.Unlock()
+		sinksMu.Lock()
+		sinks = sinks[:n]
+		sinksMu.Unlock()
+	}()
+
+	config := OTelConfig{MeterProvider: noop.NewMeterProvider()}
+	for i := 0; i < 2; i++ {
+		if err := EnableOTel(config); err != nil {
+			t.Fatalf("EnableOTel #%d: %v", i+1, err)
+		}
+	}
+	sinksMu.RLock()
+	added := len(sinks) - n
+	sinksMu.RUnlock()
+	if added != 1 {
+		t.Errorf("EnableOTel twice added %d sinks, want 1", added)
+	}
+
+	config.DisablePrometheus = true
+	if err := EnableOTel(config); err == nil {
+		t.Error("EnableOTel with another config succeeded")
+	}
+	if prometheusDisabled.Load() {
+		t.Error("a failed EnableOTel disabled Prometheus")
+	}
+}
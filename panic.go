@@ -0,0 +1,73 @@
+package microbot
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"strings"
+
+	"github.com/pangpanglabs/microbot/utils"
+)
+
+// PanicHandler handles a panic recovered by the middleware, after it has been
+// logged and recorded. It is responsible for writing the response.
+type PanicHandler func(w http.ResponseWriter, r *http.Request, err error, stack []byte, route string)
+
+// DefaultPanicHandler writes a 500 response, as JSON if the client accepts it.
+// Nothing is written if the response has already started.
+func DefaultPanicHandler(w http.ResponseWriter, r *http.Request, err error, stack []byte, route string) {
+	if sw, ok := w.(*StatusWriter); ok && sw.status != 0 {
+		return
+	}
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(http.StatusInternalServerError)
+		utils.RenderErrorJson(w, err)
+		return
+	}
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
+
+// recoverPanic converts a recovered value to an error, captures the stack,
+// then records the panic in its group and in metrics. Only the first panic of
+// a group is logged with its stack and recorded as a key event.
+func recoverPanic(ctx context.Context, config MiddlewareConfig, v interface{}, method, path, route string) (error, []byte) {
+	err, ok := v.(error)
+	if !ok {
+		err = fmt.Errorf("%v", v)
+	}
+	stack := make([]byte, config.StackSize)
+	length := runtime.Stack(stack, !config.DisableStackAll)
+	stack = stack[:length]
+
+	fingerprint, frames := panicFingerprint()
+	group := panicRegistry.record(fingerprint, frames, err, stack, method+" "+path, route)
+
+	logger := config.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	attrs := []interface{}{
+		"error", err, "method", method, "route", route,
+		"fingerprint", group.Fingerprint, "count", group.Count,
+	}
+	if group.Count == 1 {
+		if !config.DisablePrintStack {
+			attrs = append(attrs, "stack", string(stack))
+		}
+		recordAutoKeyEvent(ctx, KeyEventPanic, fmt.Sprintf("%s %s: %v", method, route, err),
+			WithSeverity(SeverityError),
+			WithAttribute("fingerprint", group.Fingerprint))
+	}
+	logger.Error("microbot: panic recovered", attrs...)
+
+	eachSink(func(sk sink) {
+		sk.recordPanic(route, group.Fingerprint)
+	})
+	if !prometheusDisabled.Load() {
+		panics.WithLabelValues(route, group.Fingerprint).Inc()
+	}
+	return err, stack
+}
@@ -0,0 +1,142 @@
+package microbot
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pangpanglabs/microbot/utils"
+)
+
+const (
+	// DefaultPanicGroupMax is the default max number of panic groups tracked,
+	// panics of further groups are counted under OtherFingerprint.
+	DefaultPanicGroupMax = 100
+
+	// OtherFingerprint groups panics once the max number of groups is reached.
+	OtherFingerprint = "other"
+
+	// panicFrames is the number of frames which makes a fingerprint.
+	panicFrames = 5
+)
+
+var panicRegistry = PanicRegistry{
+	groups: make(map[string]*PanicGroup),
+	max:    DefaultPanicGroupMax,
+}
+
+// PanicGroup is a group of panics sharing the same top frames.
+type PanicGroup struct {
+	Fingerprint string    `json:"fingerprint"`
+	Error       string    `json:"error"`
+	Route       string    `json:"route"`
+	Request     string    `json:"request"`
+	Frames      []string  `json:"frames"`
+	Stack       string    `json:"stack"`
+	Count       int64     `json:"count"`
+	FirstSeen   time.Time `json:"firstSeen"`
+	LastSeen    time.Time `json:"lastSeen"`
+}
+
+type PanicRegistry struct {
+	mu     sync.RWMutex
+	groups map[string]*PanicGroup
+	max    int
+}
+
+func PanicsController() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		utils.RenderJson(w, panicRegistry.Groups())
+	})
+}
+
+// SetPanicGroupMax sets the max number of panic groups tracked.
+func SetPanicGroupMax(max int) {
+	if max < 1 {
+		panic("microbot: invalid panic group max")
+	}
+	panicRegistry.mu.Lock()
+	defer panicRegistry.mu.Unlock()
+	panicRegistry.max = max
+}
+
+// Groups returns copies of the panic groups, most recently seen first.
+func (p *PanicRegistry) Groups() []PanicGroup {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	groups := make([]PanicGroup, 0, len(p.groups))
+	for _, g := range p.groups {
+		groups = append(groups, *g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].LastSeen.After(groups[j].LastSeen)
+	})
+	return groups
+}
+
+// record adds a panic to its group and returns a copy of the group.
+func (p *PanicRegistry) record(fingerprint string, frames []string, err error, stack []byte, request, route string) PanicGroup {
+	now := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	g, ok := p.groups[fingerprint]
+	if !ok {
+		if len(p.groups) >= p.max {
+			fingerprint = OtherFingerprint
+			g, ok = p.groups[fingerprint]
+		}
+		if !ok {
+			g = &PanicGroup{
+				Fingerprint: fingerprint,
+				Error:       err.Error(),
+				Route:       route,
+				Request:     request,
+				Frames:      frames,
+				Stack:       string(stack),
+				FirstSeen:   now,
+			}
+			p.groups[fingerprint] = g
+		}
+	}
+	g.Count++
+	g.LastSeen = now
+	return *g
+}
+
+// panicFingerprint hashes the top frames of the panicking goroutine. It must
+// be called from the deferred function which recovers.
+func panicFingerprint() (string, []string) {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(1, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	var top []string
+	panicking := false
+	for {
+		frame, more := frames.Next()
+		if panicking && !strings.HasPrefix(frame.Function, "runtime.") {
+			top = append(top, fmt.Sprintf("%s:%d", frame.Function, frame.Line))
+			if len(top) == panicFrames {
+				break
+			}
+		}
+		if frame.Function == "runtime.gopanic" {
+			panicking = true
+		}
+		if !more {
+			break
+		}
+	}
+
+	h := fnv.New64a()
+	for _, f := range top {
+		h.Write([]byte(f))
+		h.Write([]byte{'\n'})
+	}
+	return fmt.Sprintf("%016x", h.Sum64()), top
+}
@@ -0,0 +1,103 @@
+package microbot
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// quietConfig does not log recovered panics.
+var quietConfig = MiddlewareConfig{
+	Logger:            slog.New(slog.NewTextHandler(io.Discard, nil)),
+	DisablePrintStack: true,
+}
+
+func panicA(w http.ResponseWriter, r *http.Request) { panic("panic a") }
+
+func panicB(w http.ResponseWriter, r *http.Request) { panic("panic b") }
+
+// servedPanicGroups returns the groups served by PanicsController by
+// fingerprint.
+func servedPanicGroups(t *testing.T) map[string]PanicGroup {
+	t.Helper()
+	w := httptest.NewRecorder()
+	PanicsController().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/panics", nil))
+	if ct := w.Header().Get("Content-Type"); ct == "" {
+		t.Error("no Content-Type")
+	}
+	var groups []PanicGroup
+	if err := json.Unmarshal(w.Body.Bytes(), &groups); err != nil {
+		t.Fatalf("%v: %s", err, w.Body)
+	}
+	byFingerprint := make(map[string]PanicGroup, len(groups))
+	for _, g := range groups {
+		byFingerprint[g.Fingerprint] = g
+	}
+	return byFingerprint
+}
+
+func TestPanicGroups(t *testing.T) {
+	m := http.NewServeMux()
+	m.HandleFunc("/a", panicA)
+	m.HandleFunc("/b", panicB)
+	h := MiddlewareWithConfig(func(r *http.Request) string { return r.URL.Path }, quietConfig)(m)
+
+	before := servedPanicGroups(t)
+	for _, path := range []string{"/a", "/b", "/a"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	after := servedPanicGroups(t)
+
+	// Each stack makes one group, counting its panics.
+	counts := make(map[string]int64)
+	for fingerprint, g := range after {
+		if n := g.Count - before[fingerprint].Count; n > 0 {
+			counts[g.Error] += n
+			if g.Error == "panic a" && (g.Route != "/a" || g.Request != "GET /a" || len(g.Frames) == 0 || g.Stack == "") {
+				t.Errorf("group of /a = %+v", g)
+			}
+			if g.LastSeen.Before(g.FirstSeen) {
+				t.Errorf("group %s last seen before first seen", fingerprint)
+			}
+		}
+	}
+	if counts["panic a"] != 2 || counts["panic b"] != 1 || len(counts) != 2 {
+		t.Errorf("new panics by error = %v, want 2 of a and 1 of b", counts)
+	}
+	var fingerprints []string
+	for fingerprint, g := range after {
+		if g.Error == "panic a" || g.Error == "panic b" {
+			fingerprints = append(fingerprints, fingerprint)
+		}
+	}
+	if len(fingerprints) != 2 {
+		t.Errorf("panics a and b are in %d groups, want 2", len(fingerprints))
+	}
+}
+
+func TestPanicRegistryMax(t *testing.T) {
+	p := &PanicRegistry{groups: make(map[string]*PanicGroup), max: 2}
+	for _, fingerprint := range []string{"1", "2", "3", "4", "1"} {
+		p.record(fingerprint, nil, errors.New("error "+fingerprint), nil, "GET /", "/")
+	}
+
+	groups := make(map[string]PanicGroup)
+	for _, g := range p.Groups() {
+		groups[g.Fingerprint] = g
+	}
+	if len(groups) != 3 {
+		t.Fatalf("%d groups, want 2 and the other one", len(groups))
+	}
+	if n := groups["1"].Count; n != 2 {
+		t.Errorf("group 1 counts %d panics, want 2", n)
+	}
+	// Groups beyond the max are counted together, as the first of them.
+	other := groups[OtherFingerprint]
+	if other.Count != 2 || other.Error != "error 3" {
+		t.Errorf("other group = %d panics of %q, want 2 of error 3", other.Count, other.Error)
+	}
+}
@@ -0,0 +1,21 @@
+//go:build go1.23
+
+package microbot
+
+import (
+	"net/http"
+	"strings"
+)
+
+// requestPattern returns the pattern matched by http.ServeMux for the request,
+// without its method and host.
+func requestPattern(r *http.Request) string {
+	p := r.Pattern
+	if i := strings.IndexAny(p, " \t"); i >= 0 {
+		p = strings.TrimLeft(p[i:], " \t")
+	}
+	if i := strings.IndexByte(p, '/'); i > 0 {
+		p = p[i:]
+	}
+	return p
+}
@@ -0,0 +1,11 @@
+//go:build !go1.23
+
+package microbot
+
+import "net/http"
+
+// requestPattern returns an empty pattern, http.Request has no Pattern field
+// before Go 1.23.
+func requestPattern(r *http.Request) string {
+	return ""
+}
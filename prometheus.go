@@ -8,6 +8,7 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
@@ -23,10 +24,16 @@ func MetricsController() http.Handler {
 		switch t {
 		case "pprof":
 			ProfController().ServeHTTP(w, r)
+		case "panics":
+			PanicsController().ServeHTTP(w, r)
 		case "db":
 			// TODO
 		default:
-			promhttp.Handler().ServeHTTP(w, r)
+			// OpenMetrics is served when negotiated, as exemplars are
+			// dropped from the text format.
+			promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{
+				EnableOpenMetrics: true,
+			}).ServeHTTP(w, r)
 		}
 	})
 }
@@ -0,0 +1,378 @@
+package microbot
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+type PushMode int
+
+const (
+	// PushGateway pushes to a Prometheus Pushgateway, replacing the metrics
+	// of the grouping key.
+	PushGateway PushMode = iota
+	// PushRemoteWrite sends to a Prometheus remote-write endpoint.
+	PushRemoteWrite
+)
+
+type (
+	// PusherConfig defines the config for Pusher.
+	PusherConfig struct {
+		// URL of the Pushgateway, or of the remote-write endpoint.
+		// Required.
+		URL string `yaml:"url"`
+
+		// Mode selects the protocol of pushes.
+		// Optional. Default value PushGateway.
+		Mode PushMode `yaml:"mode"`
+
+		// Job is the job label of pushed metrics.
+		// Required.
+		Job string `yaml:"job"`
+
+		// Grouping labels are added to the grouping key of the Pushgateway,
+		// or to every series sent with remote-write.
+		// Optional. Default value nil.
+		Grouping map[string]string `yaml:"grouping"`
+
+		// Interval between periodic pushes.
+		// Optional. Default value 15 seconds.
+		Interval time.Duration `yaml:"interval"`
+
+		// MaxRetries of a failed push.
+		// Optional. Default value 3.
+		MaxRetries int `yaml:"max_retries"`
+
+		// Backoff before the first retry, doubled on each further retry.
+		// Optional. Default value 500 milliseconds.
+		Backoff time.Duration `yaml:"backoff"`
+
+		// Client sends the pushes.
+		// Optional. Default value http.DefaultClient.
+		Client *http.Client `yaml:"-"`
+
+		// Gatherer collects the pushed metrics.
+		// Optional. Default value prometheus.DefaultGatherer.
+		Gatherer prometheus.Gatherer `yaml:"-"`
+
+		// OnFlush is called with the result of the final push made by Stop.
+		// Optional. Default value nil.
+		OnFlush func(err error) `yaml:"-"`
+	}
+
+	// Pusher pushes metrics periodically and on Stop, for batch jobs which
+	// exit before being scraped.
+	Pusher struct {
+		config  PusherConfig
+		stop    chan struct{}
+		done    chan struct{}
+		started sync.Once
+		stopped sync.Once
+	}
+
+	// pushError is a push failure which is not worth retrying.
+	pushError struct {
+		err error
+	}
+
+	// remoteLabel, remoteSample and remoteSeries mirror the messages of the
+	// remote-write protocol, to avoid depending on the Prometheus server
+	// module for them.
+	remoteLabel struct {
+		Name, Value string
+	}
+	remoteSample struct {
+		Value     float64
+		Timestamp int64
+	}
+	remoteSeries struct {
+		Labels  []remoteLabel
+		Samples []remoteSample
+	}
+)
+
+var (
+	// DefaultPusherConfig is the default Pusher config.
+	DefaultPusherConfig = PusherConfig{
+		Mode:       PushGateway,
+		Interval:   15 * time.Second,
+		MaxRetries: 3,
+		Backoff:    500 * time.Millisecond,
+	}
+)
+
+func (e pushError) Error() string { return e.err.Error() }
+
+func NewPusher(config PusherConfig) (*Pusher, error) {
+	if config.URL == "" {
+		return nil, errors.New("microbot: Pusher URL is required")
+	}
+	if config.Job == "" {
+		return nil, errors.New("microbot: Pusher Job is required")
+	}
+	// Defaults
+	if config.Interval == 0 {
+		config.Interval = DefaultPusherConfig.Interval
+	}
+	if config.MaxRetries == 0 {
+		config.MaxRetries = DefaultPusherConfig.MaxRetries
+	}
+	if config.Backoff == 0 {
+		config.Backoff = DefaultPusherConfig.Backoff
+	}
+	if config.Client == nil {
+		config.Client = http.DefaultClient
+	}
+	if config.Gatherer == nil {
+		config.Gatherer = prometheus.DefaultGatherer
+	}
+	return &Pusher{
+		config: config,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}, nil
+}
+
+// Start pushes periodically until Stop is called. Started pushers are
+// stopped by Shutdown.
+func (p *Pusher) Start() {
+	p.started.Do(func() {
+		registerPusher(p)
+		p.run()
+	})
+}
+
+func (p *Pusher) run() {
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(p.config.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), p.config.Interval)
+				p.Push(ctx)
+				cancel()
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops periodic pushes and makes a final push, whose result is passed
+// to OnFlush and returned.
+func (p *Pusher) Stop(ctx context.Context) error {
+	p.started.Do(func() {
+		// Never started, there is no loop to wait for.
+		close(p.done)
+	})
+	p.stopped.Do(func() {
+		close(p.stop)
+	})
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	err := p.Push(ctx)
+	if p.config.OnFlush != nil {
+		p.config.OnFlush(err)
+	}
+	return err
+}
+
+// Push pushes the metrics once, retrying with backoff on failure.
+func (p *Pusher) Push(ctx context.Context) error {
+	var err error
+	backoff := p.config.Backoff
+	for i := 0; i <= p.config.MaxRetries; i++ {
+		if i > 0 {
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			backoff *= 2
+		}
+		if p.config.Mode == PushRemoteWrite {
+			err = p.remoteWrite(ctx)
+		} else {
+			err = p.pushGateway(ctx)
+		}
+		if _, ok := err.(pushError); err == nil || ok {
+			return err
+		}
+	}
+	return err
+}
+
+func (p *Pusher) pushGateway(ctx context.Context) error {
+	pusher := push.New(p.config.URL, p.config.Job).
+		Gatherer(p.config.Gatherer).
+		Client(p.config.Client)
+	for k, v := range p.config.Grouping {
+		pusher = pusher.Grouping(k, v)
+	}
+	return pusher.PushContext(ctx)
+}
+
+func (p *Pusher) remoteWrite(ctx context.Context) error {
+	mfs, err := p.config.Gatherer.Gather()
+	if err != nil {
+		return err
+	}
+	labels := map[string]string{"job": p.config.Job}
+	for k, v := range p.config.Grouping {
+		labels[k] = v
+	}
+	data := marshalWriteRequest(toTimeSeries(mfs, labels, time.Now().UnixNano()/int64(time.Millisecond)))
+
+	httpReq, err := http.NewRequest(http.MethodPost, p.config.URL, bytes.NewReader(snappy.Encode(nil, data)))
+	if err != nil {
+		return pushError{err}
+	}
+	httpReq = httpReq.WithContext(ctx)
+	httpReq.Header.Set("Content-Encoding", "snappy")
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	httpReq.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	resp, err := p.config.Client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		err = fmt.Errorf("microbot: remote-write failed with status %d", resp.StatusCode)
+		// Client errors are retried only when rate limited.
+		if resp.StatusCode/100 == 4 && resp.StatusCode != http.StatusTooManyRequests {
+			return pushError{err}
+		}
+		return err
+	}
+	return nil
+}
+
+// toTimeSeries converts metric families to remote-write series, expanding
+// summaries and histograms the way the text format does.
+func toTimeSeries(mfs []*dto.MetricFamily, extra map[string]string, now int64) []remoteSeries {
+	var series []remoteSeries
+	for _, mf := range mfs {
+		name := mf.GetName()
+		for _, m := range mf.GetMetric() {
+			ts := now
+			if m.GetTimestampMs() != 0 {
+				ts = m.GetTimestampMs()
+			}
+			base := make(map[string]string, len(extra)+len(m.GetLabel()))
+			for k, v := range extra {
+				base[k] = v
+			}
+			for _, l := range m.GetLabel() {
+				base[l.GetName()] = l.GetValue()
+			}
+			add := func(name string, v float64, extraName, extraValue string) {
+				labels := []remoteLabel{{Name: "__name__", Value: name}}
+				for k, v := range base {
+					labels = append(labels, remoteLabel{Name: k, Value: v})
+				}
+				if extraName != "" {
+					labels = append(labels, remoteLabel{Name: extraName, Value: extraValue})
+				}
+				sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+				series = append(series, remoteSeries{
+					Labels:  labels,
+					Samples: []remoteSample{{Value: v, Timestamp: ts}},
+				})
+			}
+
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				add(name, m.GetCounter().GetValue(), "", "")
+			case dto.MetricType_GAUGE:
+				add(name, m.GetGauge().GetValue(), "", "")
+			case dto.MetricType_UNTYPED:
+				add(name, m.GetUntyped().GetValue(), "", "")
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.GetQuantile() {
+					add(name, q.GetValue(), "quantile", formatFloat(q.GetQuantile()))
+				}
+				add(name+"_sum", s.GetSampleSum(), "", "")
+				add(name+"_count", float64(s.GetSampleCount()), "", "")
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				for _, b := range h.GetBucket() {
+					if math.IsInf(b.GetUpperBound(), 1) {
+						continue
+					}
+					add(name+"_bucket", float64(b.GetCumulativeCount()), "le", formatFloat(b.GetUpperBound()))
+				}
+				add(name+"_bucket", float64(h.GetSampleCount()), "le", "+Inf")
+				add(name+"_sum", h.GetSampleSum(), "", "")
+				add(name+"_count", float64(h.GetSampleCount()), "", "")
+			}
+		}
+	}
+	return series
+}
+
+// marshalWriteRequest encodes series as a prometheus.WriteRequest protobuf
+// message. Fields with zero values are omitted, as proto3 does.
+func marshalWriteRequest(series []remoteSeries) []byte {
+	var b []byte
+	for _, s := range series {
+		var ts []byte
+		for _, l := range s.Labels {
+			var lb []byte
+			lb = appendString(lb, 1, l.Name)
+			lb = appendString(lb, 2, l.Value)
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, lb)
+		}
+		for _, smp := range s.Samples {
+			var sb []byte
+			if bits := math.Float64bits(smp.Value); bits != 0 {
+				sb = protowire.AppendTag(sb, 1, protowire.Fixed64Type)
+				sb = protowire.AppendFixed64(sb, bits)
+			}
+			if smp.Timestamp != 0 {
+				sb = protowire.AppendTag(sb, 2, protowire.VarintType)
+				sb = protowire.AppendVarint(sb, uint64(smp.Timestamp))
+			}
+			ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, sb)
+		}
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, ts)
+	}
+	return b
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,125 @@
+package microbot
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// fields decodes the top-level fields of a protobuf message, keeping only
+// the bytes and fixed64 ones which remote-write uses.
+func fields(t *testing.T, b []byte) map[protowire.Number][][]byte {
+	t.Helper()
+	m := map[protowire.Number][][]byte{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+		b = b[n:]
+		var v []byte
+		switch typ {
+		case protowire.BytesType:
+			v, n = protowire.ConsumeBytes(b)
+		case protowire.Fixed64Type:
+			n = 8
+			v = b[:8]
+		case protowire.VarintType:
+			_, n = protowire.ConsumeVarint(b)
+			v = b[:n]
+		default:
+			t.Fatalf("unexpected wire type %d", typ)
+		}
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+		m[num] = append(m[num], v)
+		b = b[n:]
+	}
+	return m
+}
+
+func TestPusherRemoteWrite(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	c := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "pushed_total"}, []string{"kind"})
+	reg.MustRegister(c)
+	c.WithLabelValues("a").Add(3)
+
+	var body []byte
+	var header http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	p, err := NewPusher(PusherConfig{
+		URL:      srv.URL,
+		Mode:     PushRemoteWrite,
+		Job:      "batch",
+		Grouping: map[string]string{"instance": "i1"},
+		Gatherer: reg,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Push(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if header.Get("Content-Encoding") != "snappy" || header.Get("Content-Type") != "application/x-protobuf" {
+		t.Errorf("headers = %v", header)
+	}
+	data, err := snappy.Decode(nil, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	series := fields(t, data)[1]
+	if len(series) != 1 {
+		t.Fatalf("%d series, want 1", len(series))
+	}
+	ts := fields(t, series[0])
+	got := map[string]string{}
+	for _, l := range ts[1] {
+		lf := fields(t, l)
+		got[string(lf[1][0])] = string(lf[2][0])
+	}
+	want := map[string]string{"__name__": "pushed_total", "job": "batch", "instance": "i1", "kind": "a"}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("label %s = %q, want %q", k, got[k], v)
+		}
+	}
+	sample := fields(t, ts[2][0])
+	v, _ := protowire.ConsumeFixed64(sample[1][0])
+	if math.Float64frombits(v) != 3 {
+		t.Errorf("value = %v, want 3", math.Float64frombits(v))
+	}
+	if len(sample[2]) != 1 {
+		t.Error("sample has no timestamp")
+	}
+}
+
+func TestPusherRemoteWriteClientError(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	p, _ := NewPusher(PusherConfig{URL: srv.URL, Mode: PushRemoteWrite, Job: "batch", Gatherer: prometheus.NewRegistry()})
+	if err := p.Push(context.Background()); err == nil {
+		t.Error("Push succeeded on status 400")
+	}
+	if calls != 1 {
+		t.Errorf("%d requests, want 1 as client errors are not retried", calls)
+	}
+}
@@ -0,0 +1,134 @@
+package microbot
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pangpanglabs/microbot/utils"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// recorder records the metrics and access logs of requests. It is shared by
+// the middlewares of every framework so that their metrics are identical.
+type recorder struct {
+	accessLog         *accessLogger
+	tracing           bool
+	exemplarExtractor func(r *http.Request) prometheus.Labels
+}
+
+func newRecorder(config MiddlewareConfig) *recorder {
+	return &recorder{
+		accessLog:         newAccessLogger(config.AccessLog),
+		tracing:           !config.DisableTracing,
+		exemplarExtractor: config.ExemplarExtractor,
+	}
+}
+
+// start counts r in flight, and returns it with its request ID and a server
+// span in its context, unless tracing is disabled.
+func (rec *recorder) start(r *http.Request) *http.Request {
+	inFlight.Add(1)
+	inFlightGauge.Inc()
+	if id := r.Header.Get(requestIDHeader); id != "" {
+		r = r.WithContext(ContextWithRequestID(r.Context(), id))
+	}
+	if !rec.tracing {
+		return r
+	}
+	return startServerSpan(r)
+}
+
+// record records a finished request. route is the matched route template,
+// empty if no route matched. errTypes are the types of the errors of the
+// request, "panic" for a recovered panic, counted in the errors metric.
+// Status 0, of a handler which wrote nothing, is recorded as 200, which is
+// what net/http answers.
+func (rec *recorder) record(sw *StatusWriter, r *http.Request, ip, route string, status int, errTypes []string) {
+	inFlight.Add(-1)
+	inFlightGauge.Dec()
+	sw.finish(route, r.Method)
+	if status == 0 {
+		status = http.StatusOK
+	}
+	s := fmt.Sprintf("%d", status)
+	var span *Span
+	if rec.tracing {
+		span = SpanFromContext(r.Context())
+	}
+	if span != nil {
+		if route != "" {
+			span.Name = r.Method + " " + route
+			span.SetAttribute("http.route", route)
+		}
+		span.SetAttribute("http.response.status_code", s)
+		if status >= http.StatusInternalServerError {
+			span.SetError(errors.New(http.StatusText(status)))
+		}
+		span.Finish()
+	}
+	if route == "/metrics" {
+		return
+	}
+	elapsed := time.Since(sw.begun)
+	eachSink(func(sk sink) {
+		sk.recordRequest(r.Method, route, status, elapsed)
+	})
+	if !prometheusDisabled.Load() {
+		rec.observe(r, span, ip, route, s, elapsed)
+	}
+	for _, t := range errTypes {
+		httpErrors.WithLabelValues(route, s, t).Inc()
+	}
+	if rec.accessLog != nil {
+		rec.accessLog.log(r, ip, route, status, sw.length, elapsed)
+	}
+}
+
+// observe records a finished request into the Prometheus registry.
+func (rec *recorder) observe(r *http.Request, span *Span, ip, route, status string, elapsed time.Duration) {
+	d := elapsed.Nanoseconds() / int64(time.Millisecond)
+	ipType := "private"
+	if utils.IsPublicIP(ip) {
+		ipType = "public"
+	}
+
+	exemplar := rec.exemplar(r, span)
+	observer := duration.WithLabelValues(route, status, r.Method, ipType)
+	if eo, ok := observer.(prometheus.ExemplarObserver); ok && exemplar != nil {
+		eo.ObserveWithExemplar(float64(d), exemplar)
+	} else {
+		observer.Observe(float64(d))
+	}
+	counter := requests.With(prometheus.Labels{
+		"handler": route,
+		"status":  status,
+		"method":  r.Method,
+		"ip_type": ipType,
+	})
+	if adder, ok := counter.(prometheus.ExemplarAdder); ok && exemplar != nil {
+		adder.AddWithExemplar(1, exemplar)
+	} else {
+		counter.Inc()
+	}
+}
+
+// exemplar returns the exemplar labels of a request, nil if it is not part of
+// a sampled trace. New traces are only sampled with a span exporter, so no
+// exemplar points to a trace which is never exported.
+func (rec *recorder) exemplar(r *http.Request, span *Span) prometheus.Labels {
+	if rec.exemplarExtractor != nil {
+		return rec.exemplarExtractor(r)
+	}
+	if span != nil {
+		if span.SpanContext.Sampled {
+			return span.SpanContext.exemplar()
+		}
+		return nil
+	}
+	if sc, ok := ParseTraceparent(r.Header.Get(traceparentHeader)); ok && sc.Sampled {
+		return sc.exemplar()
+	}
+	return nil
+}
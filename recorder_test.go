@@ -0,0 +1,91 @@
+package microbot
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// requestExemplar returns the exemplar labels of the requests counter.
+func requestExemplar(t *testing.T, route string) map[string]string {
+	t.Helper()
+	var m dto.Metric
+	if err := requests.WithLabelValues(route, "200", http.MethodGet, "private").(prometheus.Metric).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	labels := map[string]string{}
+	for _, l := range m.GetCounter().GetExemplar().GetLabel() {
+		labels[l.GetName()] = l.GetValue()
+	}
+	return labels
+}
+
+func TestExemplars(t *testing.T) {
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		sampled = "00-" + traceID + "-00f067aa0ba902b7-01"
+	)
+	tests := []struct {
+		name        string
+		traceparent string
+		exporter    bool
+		config      MiddlewareConfig
+		want        string
+	}{
+		{"none", "", false, quietConfig, ""},
+		{"not sampled", "00-" + traceID + "-00f067aa0ba902b7-00", false, quietConfig, ""},
+		{"sampled", sampled, false, quietConfig, traceID},
+		{"exporter", "", true, quietConfig, "new"},
+		{"tracing disabled", sampled, false, MiddlewareConfig{
+			Logger:         quietConfig.Logger,
+			DisableTracing: true,
+		}, traceID},
+		{"extractor", "", false, MiddlewareConfig{
+			Logger: quietConfig.Logger,
+			ExemplarExtractor: func(r *http.Request) prometheus.Labels {
+				return prometheus.Labels{"trace_id": "custom"}
+			},
+		}, "custom"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.exporter {
+				SetSpanExporter(&InMemoryExporter{})
+				defer func() {
+					FlushSpans(context.Background())
+					SetSpanExporter(nil)
+				}()
+			}
+			route := "/exemplar/" + tt.name
+			h := MiddlewareWithConfig(func(*http.Request) string { return route }, tt.config)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = "10.0.0.1:1234"
+			if tt.traceparent != "" {
+				r.Header.Set(traceparentHeader, tt.traceparent)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), r)
+
+			got := requestExemplar(t, route)["trace_id"]
+			switch tt.want {
+			case "":
+				if got != "" {
+					t.Errorf("exemplar trace_id = %q, want none", got)
+				}
+			case "new":
+				if len(got) != 32 || got == traceID {
+					t.Errorf("exemplar trace_id = %q, want a new trace", got)
+				}
+			default:
+				if got != tt.want {
+					t.Errorf("exemplar trace_id = %q, want %q", got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -9,27 +9,33 @@ import (
 )
 
 type DBPingResult struct {
+	// index of the DB in the order of RegisterDB, which tells apart DBs of
+	// the same type.
+	index    int
 	dbType   db.DBType
 	duration int64
 	err      error
 }
 
+// PingDB pings the registered DBs, returning their results in the order of
+// RegisterDB.
 func PingDB() []DBPingResult {
-	var results []DBPingResult
+	results := make([]DBPingResult, len(dialects))
 	var wg sync.WaitGroup
-	for _, d := range dialects {
+	for i, d := range dialects {
 		wg.Add(1)
-		go func(dt db.Dialect) {
+		go func(i int, dt db.Dialect) {
 			defer wg.Done()
 			start := time.Now()
 			err := dt.DB().Ping()
 			duration := time.Since(start).Nanoseconds()
-			results = append(results, DBPingResult{
+			results[i] = DBPingResult{
+				index:    i,
 				dbType:   dt.DBType(),
 				duration: duration,
 				err:      err,
-			})
-		}(d)
+			}
+		}(i, d)
 	}
 	wg.Wait()
 	return results
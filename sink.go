@@ -0,0 +1,51 @@
+package microbot
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pangpanglabs/microbot/db"
+)
+
+// sink mirrors the metrics recorded by microbot to a system other than the
+// Prometheus registry.
+type sink interface {
+	recordRequest(method, route string, status int, elapsed time.Duration)
+	recordPanic(route, fingerprint string)
+	recordKeyEvent(t string)
+	recordDBPing(dbType db.DBType, err error, elapsed time.Duration)
+}
+
+var (
+	sinksMu sync.RWMutex
+	sinks   []sink
+
+	// prometheusDisabled stops recording into the Prometheus registry.
+	prometheusDisabled atomic.Bool
+)
+
+func addSink(s sink) {
+	sinksMu.Lock()
+	defer sinksMu.Unlock()
+	sinks = append(sinks, s)
+}
+
+func removeSink(s sink) {
+	sinksMu.Lock()
+	defer sinksMu.Unlock()
+	for i, sk := range sinks {
+		if sk == s {
+			sinks = append(sinks[:i:i], sinks[i+1:]...)
+			return
+		}
+	}
+}
+
+func eachSink(f func(s sink)) {
+	sinksMu.RLock()
+	defer sinksMu.RUnlock()
+	for _, s := range sinks {
+		f(s)
+	}
+}
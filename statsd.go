@@ -0,0 +1,268 @@
+package microbot
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pangpanglabs/microbot/db"
+)
+
+type (
+	// StatsDConfig defines the config for StatsD.
+	StatsDConfig struct {
+		// Addr of the agent, "host:port" for UDP or a socket path for Unix.
+		// Required.
+		Addr string `yaml:"addr"`
+
+		// Network of the agent, "udp" or "unixgram".
+		// Optional. Default value "udp".
+		Network string `yaml:"network"`
+
+		// Prefix of metric names.
+		// Optional. Default value "microbot.".
+		Prefix string `yaml:"prefix"`
+
+		// DogStatsD enables tags. Plain StatsD has no tags, so they are
+		// dropped without it.
+		// Optional. Default value false.
+		DogStatsD bool `yaml:"dogstatsd"`
+
+		// Tags added to every metric.
+		// Optional. Default value nil.
+		Tags map[string]string `yaml:"tags"`
+
+		// FlushInterval between sends of the aggregated metrics.
+		// Optional. Default value 1 second.
+		FlushInterval time.Duration `yaml:"flush_interval"`
+
+		// MaxPacketSize of a datagram.
+		// Optional. Default value 1432, which fits in an Ethernet MTU.
+		MaxPacketSize int `yaml:"max_packet_size"`
+
+		// MaxBuffered is the number of timings kept between flushes, further
+		// timings are dropped.
+		// Optional. Default value 10000.
+		MaxBuffered int `yaml:"max_buffered"`
+	}
+
+	// StatsD mirrors the request, panic, key event and DB metrics to a
+	// StatsD or DogStatsD agent. Counters are aggregated client-side, timings
+	// are buffered, and both are sent on each flush.
+	StatsD struct {
+		config   StatsDConfig
+		conn     net.Conn
+		tags     string
+		mu       sync.Mutex
+		counters map[statsdKey]int64
+		timings  []string
+		stop     chan struct{}
+		done     chan struct{}
+		once     sync.Once
+	}
+
+	statsdKey struct {
+		name string
+		tags string
+	}
+)
+
+var (
+	// DefaultStatsDConfig is the default StatsD config.
+	DefaultStatsDConfig = StatsDConfig{
+		Network:       "udp",
+		Prefix:        "microbot.",
+		FlushInterval: time.Second,
+		MaxPacketSize: 1432,
+		MaxBuffered:   10000,
+	}
+
+	statsdTagReplacer = strings.NewReplacer(",", "_", "|", "_", "#", "_", "\n", "_")
+)
+
+// EnableStatsD starts mirroring metrics to a StatsD agent, alongside the
+// Prometheus registry.
+func EnableStatsD(config StatsDConfig) (*StatsD, error) {
+	s, err := NewStatsD(config)
+	if err != nil {
+		return nil, err
+	}
+	addSink(s)
+	return s, nil
+}
+
+func NewStatsD(config StatsDConfig) (*StatsD, error) {
+	if config.Addr == "" {
+		return nil, errors.New("microbot: StatsD Addr is required")
+	}
+	// Defaults
+	if config.Network == "" {
+		config.Network = DefaultStatsDConfig.Network
+	}
+	if config.Prefix == "" {
+		config.Prefix = DefaultStatsDConfig.Prefix
+	}
+	if config.FlushInterval == 0 {
+		config.FlushInterval = DefaultStatsDConfig.FlushInterval
+	}
+	if config.MaxPacketSize == 0 {
+		config.MaxPacketSize = DefaultStatsDConfig.MaxPacketSize
+	}
+	if config.MaxBuffered == 0 {
+		config.MaxBuffered = DefaultStatsDConfig.MaxBuffered
+	}
+	conn, err := net.Dial(config.Network, config.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &StatsD{
+		config:   config,
+		conn:     conn,
+		counters: make(map[statsdKey]int64),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	var tags []string
+	for k, v := range config.Tags {
+		tags = append(tags, statsdTag(k, v))
+	}
+	sort.Strings(tags)
+	s.tags = strings.Join(tags, ",")
+
+	go s.run()
+	return s, nil
+}
+
+func (s *StatsD) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.config.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.Flush()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Close stops mirroring metrics to the agent, flushes the buffered metrics
+// and closes the connection. Calls after the first one return nil.
+func (s *StatsD) Close() error {
+	var err error
+	s.once.Do(func() {
+		removeSink(s)
+		close(s.stop)
+		<-s.done
+		err = s.Flush()
+		if cerr := s.conn.Close(); err == nil {
+			err = cerr
+		}
+	})
+	return err
+}
+
+// Flush sends the aggregated metrics, packing lines into datagrams of at most
+// MaxPacketSize bytes.
+func (s *StatsD) Flush() error {
+	s.mu.Lock()
+	lines := s.timings
+	s.timings = nil
+	for key, n := range s.counters {
+		lines = append(lines, key.name+":"+strconv.FormatInt(n, 10)+"|c"+key.tags)
+	}
+	s.counters = make(map[statsdKey]int64)
+	s.mu.Unlock()
+
+	var err error
+	var buf bytes.Buffer
+	send := func() {
+		if buf.Len() == 0 {
+			return
+		}
+		if _, werr := s.conn.Write(buf.Bytes()); werr != nil && err == nil {
+			err = werr
+		}
+		buf.Reset()
+	}
+	for _, line := range lines {
+		if buf.Len() > 0 && buf.Len()+1+len(line) > s.config.MaxPacketSize {
+			send()
+		}
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(line)
+	}
+	send()
+	return err
+}
+
+func (s *StatsD) count(name string, tags ...string) {
+	key := statsdKey{s.config.Prefix + name, s.tagSuffix(tags)}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counters[key]++
+}
+
+func (s *StatsD) timing(name string, d time.Duration, tags ...string) {
+	ms := strconv.FormatFloat(float64(d.Nanoseconds())/float64(time.Millisecond), 'f', 3, 64)
+	line := s.config.Prefix + name + ":" + ms + "|ms" + s.tagSuffix(tags)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.timings) < s.config.MaxBuffered {
+		s.timings = append(s.timings, line)
+	}
+}
+
+// tagSuffix formats key/value pairs as a DogStatsD tag suffix.
+func (s *StatsD) tagSuffix(kvs []string) string {
+	if !s.config.DogStatsD {
+		return ""
+	}
+	tags := make([]string, 0, len(kvs)/2+1)
+	if s.tags != "" {
+		tags = append(tags, s.tags)
+	}
+	for i := 0; i+1 < len(kvs); i += 2 {
+		tags = append(tags, statsdTag(kvs[i], kvs[i+1]))
+	}
+	if len(tags) == 0 {
+		return ""
+	}
+	return "|#" + strings.Join(tags, ",")
+}
+
+func statsdTag(k, v string) string {
+	return statsdTagReplacer.Replace(k) + ":" + statsdTagReplacer.Replace(v)
+}
+
+func (s *StatsD) recordRequest(method, route string, status int, elapsed time.Duration) {
+	tags := []string{"route", route, "method", method, "status", strconv.Itoa(status)}
+	s.count("http.requests", tags...)
+	s.timing("http.request.duration", elapsed, tags...)
+}
+
+func (s *StatsD) recordPanic(route, fingerprint string) {
+	s.count("panics", "route", route, "fingerprint", fingerprint)
+}
+
+func (s *StatsD) recordKeyEvent(t string) {
+	s.count("key_events", "type", t)
+}
+
+func (s *StatsD) recordDBPing(dbType db.DBType, err error, elapsed time.Duration) {
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	s.count("db.accessibility", "db", string(dbType), "status", status)
+	s.timing("db.ping.duration", elapsed, "db", string(dbType))
+}
@@ -0,0 +1,51 @@
+package microbot
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsD(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	s, err := EnableStatsD(StatsDConfig{
+		Addr:          pc.LocalAddr().String(),
+		DogStatsD:     true,
+		Tags:          map[string]string{"env": "test"},
+		FlushInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.recordPanic("/a", "f1")
+	s.recordPanic("/a", "f1")
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+
+	eachSink(func(sk sink) {
+		if sk == s {
+			t.Error("closed StatsD is still a sink")
+		}
+	})
+
+	buf := make([]byte, 2048)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "microbot.panics:2|c|#env:test,route:/a,fingerprint:f1"
+	if got := string(buf[:n]); !strings.Contains(got, want) {
+		t.Errorf("datagram = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,200 @@
+package microbot
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	traceparentHeader = "Traceparent"
+	tracestateHeader  = "Tracestate"
+)
+
+type (
+	TraceID [16]byte
+	SpanID  [8]byte
+
+	// SpanContext identifies a span across process boundaries, as carried by
+	// the W3C traceparent and tracestate headers.
+	SpanContext struct {
+		TraceID    TraceID
+		SpanID     SpanID
+		Sampled    bool
+		TraceState string
+	}
+
+	SpanKind int
+
+	// Span is a timed operation of a trace.
+	Span struct {
+		Name        string
+		Kind        SpanKind
+		SpanContext SpanContext
+		Parent      SpanID
+		Start       time.Time
+		End         time.Time
+		Attributes  map[string]string
+		Error       string
+
+		mu    sync.Mutex
+		ended bool
+	}
+
+	spanKey struct{}
+)
+
+const (
+	SpanKindInternal SpanKind = iota + 1
+	SpanKindServer
+	SpanKindClient
+)
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+func (t TraceID) IsValid() bool  { return t != TraceID{} }
+func (s SpanID) String() string  { return hex.EncodeToString(s[:]) }
+func (s SpanID) IsValid() bool   { return s != SpanID{} }
+
+// ParseTraceparent parses a W3C traceparent header value.
+func ParseTraceparent(h string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+	// Version 00 has exactly four fields, later versions may add more.
+	if parts[0] == "00" && len(parts) != 4 {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent formats the span context as a W3C traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	flags := 0
+	if sc.Sampled {
+		flags = 1
+	}
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, flags)
+}
+
+// ContextWithSpan returns a copy of ctx carrying span.
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the span in ctx, or nil if there is none.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// StartSpan starts a span which is a child of the span in ctx, or the root
+// of a new trace if there is none. The span must be ended with Finish.
+func StartSpan(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	var parent SpanContext
+	if p := SpanFromContext(ctx); p != nil {
+		parent = p.SpanContext
+	}
+	span := newSpan(name, kind, parent)
+	return ContextWithSpan(ctx, span), span
+}
+
+func newSpan(name string, kind SpanKind, parent SpanContext) *Span {
+	span := &Span{
+		Name:       name,
+		Kind:       kind,
+		Start:      time.Now(),
+		Attributes: make(map[string]string),
+	}
+	if parent.TraceID.IsValid() {
+		span.SpanContext = parent
+		span.Parent = parent.SpanID
+	} else {
+		// New traces are only sampled when there is an exporter, so that
+		// downstream services are not told to keep spans nobody collects.
+		rand.Read(span.SpanContext.TraceID[:])
+		span.SpanContext.Sampled = spans.exporting()
+	}
+	rand.Read(span.SpanContext.SpanID[:])
+	return span
+}
+
+// startServerSpan starts a server span for r, continuing the trace of its
+// traceparent header if any.
+func startServerSpan(r *http.Request) *http.Request {
+	parent, ok := ParseTraceparent(r.Header.Get(traceparentHeader))
+	if ok {
+		parent.TraceState = r.Header.Get(tracestateHeader)
+	}
+	span := newSpan(r.Method, SpanKindServer, parent)
+	span.SetAttribute("http.request.method", r.Method)
+	span.SetAttribute("url.path", r.URL.Path)
+	return r.WithContext(ContextWithSpan(r.Context(), span))
+}
+
+// injectSpan sets the traceparent and tracestate headers of h from span.
+func injectSpan(h http.Header, span *Span) {
+	h.Set(traceparentHeader, span.SpanContext.Traceparent())
+	if span.SpanContext.TraceState != "" {
+		h.Set(tracestateHeader, span.SpanContext.TraceState)
+	}
+}
+
+func (s *Span) SetAttribute(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Attributes[key] = value
+}
+
+func (s *Span) SetError(err error) {
+	if err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Error = err.Error()
+}
+
+// Finish ends the span and hands it to the exporter if it is sampled.
+// Calls after the first one are ignored.
+func (s *Span) Finish() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.End = time.Now()
+	s.mu.Unlock()
+	if s.SpanContext.Sampled {
+		spans.enqueue(s)
+	}
+}
+
+// exemplar returns the labels of an exemplar pointing to the span context.
+func (sc SpanContext) exemplar() prometheus.Labels {
+	return prometheus.Labels{
+		"trace_id": sc.TraceID.String(),
+		"span_id":  sc.SpanID.String(),
+	}
+}
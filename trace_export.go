@@ -0,0 +1,274 @@
+package microbot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	spanQueueSize  = 2048
+	spanBatchSize  = 512
+	spanBatchDelay = 5 * time.Second
+)
+
+// SpanExporter exports finished spans to a tracing backend.
+type SpanExporter interface {
+	ExportSpans(ctx context.Context, spans []*Span) error
+	Shutdown(ctx context.Context) error
+}
+
+// spanProcessor batches finished spans and exports them in the background.
+type spanProcessor struct {
+	mu       sync.RWMutex
+	exporter SpanExporter
+	queue    chan *Span
+	flush    chan chan struct{}
+}
+
+var spans = &spanProcessor{
+	queue: make(chan *Span, spanQueueSize),
+	flush: make(chan chan struct{}),
+}
+
+func init() {
+	go spans.run()
+}
+
+// SetSpanExporter sets the exporter of finished spans. Spans are still
+// created and propagated without an exporter, but new traces are not
+// sampled and finished spans are dropped.
+func SetSpanExporter(e SpanExporter) {
+	spans.mu.Lock()
+	defer spans.mu.Unlock()
+	spans.exporter = e
+}
+
+// FlushSpans exports the spans finished so far.
+func FlushSpans(ctx context.Context) error {
+	done := make(chan struct{})
+	select {
+	case spans.flush <- done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// exporting reports whether an exporter is set.
+func (p *spanProcessor) exporting() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.exporter != nil
+}
+
+func (p *spanProcessor) enqueue(s *Span) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.exporter == nil {
+		return
+	}
+	// Drop rather than block the request when the exporter lags behind.
+	select {
+	case p.queue <- s:
+	default:
+	}
+}
+
+func (p *spanProcessor) run() {
+	ticker := time.NewTicker(spanBatchDelay)
+	defer ticker.Stop()
+	var batch []*Span
+	for {
+		select {
+		case s := <-p.queue:
+			batch = append(batch, s)
+			if len(batch) >= spanBatchSize {
+				batch = p.export(batch)
+			}
+		case <-ticker.C:
+			batch = p.export(batch)
+		case done := <-p.flush:
+			for len(p.queue) > 0 {
+				batch = append(batch, <-p.queue)
+			}
+			batch = p.export(batch)
+			close(done)
+		}
+	}
+}
+
+func (p *spanProcessor) export(batch []*Span) []*Span {
+	if len(batch) == 0 {
+		return batch
+	}
+	p.mu.RLock()
+	e := p.exporter
+	p.mu.RUnlock()
+	if e != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if err := e.ExportSpans(ctx, batch); err != nil {
+			slog.Error("microbot: export spans", "error", err, "spans", len(batch))
+		}
+		cancel()
+	}
+	return batch[:0:0]
+}
+
+// InMemoryExporter keeps exported spans in memory, for tests.
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+func (e *InMemoryExporter) ExportSpans(ctx context.Context, spans []*Span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func (e *InMemoryExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+// Spans returns the spans exported so far.
+func (e *InMemoryExporter) Spans() []*Span {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]*Span(nil), e.spans...)
+}
+
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = nil
+}
+
+// OTLPHTTPExporter exports spans with the OTLP/HTTP protocol in its JSON
+// encoding.
+type OTLPHTTPExporter struct {
+	// Endpoint receiving the spans, e.g. "http://localhost:4318/v1/traces".
+	Endpoint string
+	// ServiceName is the service.name resource attribute.
+	ServiceName string
+	// Headers are added to each export request.
+	Headers map[string]string
+	// Client sends export requests. Optional. Default value http.DefaultClient.
+	Client *http.Client
+}
+
+type (
+	otlpValue struct {
+		StringValue string `json:"stringValue"`
+	}
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		TraceState        string          `json:"traceState,omitempty"`
+		Name              string          `json:"name"`
+		Kind              int             `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes"`
+		Status            otlpStatus      `json:"status"`
+	}
+	otlpScopeSpans struct {
+		Scope struct {
+			Name string `json:"name"`
+		} `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpResourceSpans struct {
+		Resource struct {
+			Attributes []otlpAttribute `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpTraces struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+)
+
+func (e *OTLPHTTPExporter) ExportSpans(ctx context.Context, spans []*Span) error {
+	scope := otlpScopeSpans{}
+	scope.Scope.Name = "microbot"
+	for _, s := range spans {
+		s.mu.Lock()
+		out := otlpSpan{
+			TraceID:           s.SpanContext.TraceID.String(),
+			SpanID:            s.SpanContext.SpanID.String(),
+			TraceState:        s.SpanContext.TraceState,
+			Name:              s.Name,
+			Kind:              int(s.Kind),
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+		}
+		if s.Parent.IsValid() {
+			out.ParentSpanID = s.Parent.String()
+		}
+		for k, v := range s.Attributes {
+			out.Attributes = append(out.Attributes, otlpAttribute{k, otlpValue{v}})
+		}
+		if s.Error != "" {
+			out.Status = otlpStatus{Code: 2, Message: s.Error}
+		}
+		s.mu.Unlock()
+		scope.Spans = append(scope.Spans, out)
+	}
+	rs := otlpResourceSpans{ScopeSpans: []otlpScopeSpans{scope}}
+	if e.ServiceName != "" {
+		rs.Resource.Attributes = []otlpAttribute{{"service.name", otlpValue{e.ServiceName}}}
+	}
+
+	body, err := json.Marshal(otlpTraces{ResourceSpans: []otlpResourceSpans{rs}})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, e.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.Headers {
+		req.Header.Set(k, v)
+	}
+	client := e.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("microbot: OTLP export failed with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (e *OTLPHTTPExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
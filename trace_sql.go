@@ -0,0 +1,61 @@
+package microbot
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pangpanglabs/microbot/db"
+)
+
+// TracedDB wraps a sql.DB so that queries made with a context are traced as
+// client spans, children of the span in the context.
+type TracedDB struct {
+	*sql.DB
+	dbType db.DBType
+}
+
+func TraceDB(d *sql.DB, dbType db.DBType) *TracedDB {
+	return &TracedDB{DB: d, dbType: dbType}
+}
+
+func (t *TracedDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	span := t.startSpan(ctx, query)
+	rows, err := t.DB.QueryContext(ctx, query, args...)
+	t.finishSpan(span, err)
+	return rows, err
+}
+
+func (t *TracedDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	span := t.startSpan(ctx, query)
+	row := t.DB.QueryRowContext(ctx, query, args...)
+	t.finishSpan(span, row.Err())
+	return row
+}
+
+func (t *TracedDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	span := t.startSpan(ctx, query)
+	res, err := t.DB.ExecContext(ctx, query, args...)
+	t.finishSpan(span, err)
+	return res, err
+}
+
+// startSpan starts a span if ctx is part of a trace.
+func (t *TracedDB) startSpan(ctx context.Context, query string) *Span {
+	if SpanFromContext(ctx) == nil {
+		return nil
+	}
+	_, span := StartSpan(ctx, "db.query", SpanKindClient)
+	span.SetAttribute("db.system", string(t.dbType))
+	span.SetAttribute("db.statement", query)
+	return span
+}
+
+func (t *TracedDB) finishSpan(span *Span, err error) {
+	if span == nil {
+		return
+	}
+	if err != sql.ErrNoRows {
+		span.SetError(err)
+	}
+	span.Finish()
+}
@@ -0,0 +1,101 @@
+package microbot
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// tracedServer returns a server behind Middleware which calls backend through
+// Transport.
+func tracedServer(t *testing.T, backend string) *httptest.Server {
+	client := &http.Client{Transport: Transport(nil, TransportOptions{})}
+	srv := httptest.NewServer(MiddlewareWithConfig(nil, quietConfig)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, _ := http.NewRequestWithContext(r.Context(), http.MethodGet, backend, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		resp.Body.Close()
+	})))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestTraceNotSampledWithoutExporter(t *testing.T) {
+	var got string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get(traceparentHeader)
+	}))
+	defer backend.Close()
+
+	resp, err := http.Get(tracedServer(t, backend.URL).URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if !strings.HasSuffix(got, "-00") {
+		t.Errorf("traceparent = %q, want not sampled", got)
+	}
+}
+
+func TestTraceExported(t *testing.T) {
+	e := &InMemoryExporter{}
+	SetSpanExporter(e)
+	defer SetSpanExporter(nil)
+
+	var got string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get(traceparentHeader)
+	}))
+	defer backend.Close()
+
+	resp, err := http.Get(tracedServer(t, backend.URL).URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if err := FlushSpans(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	sc, ok := ParseTraceparent(got)
+	if !ok || !sc.Sampled {
+		t.Fatalf("traceparent = %q, want sampled", got)
+	}
+	spans := e.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("exported %d spans, want 2", len(spans))
+	}
+	client, server := spans[0], spans[1]
+	if client.Kind != SpanKindClient || server.Kind != SpanKindServer {
+		t.Fatalf("span kinds = %d, %d", client.Kind, server.Kind)
+	}
+	if client.SpanContext.TraceID != server.SpanContext.TraceID || client.Parent != server.SpanContext.SpanID {
+		t.Error("client span is no child of the server span")
+	}
+	if sc.SpanID != client.SpanContext.SpanID {
+		t.Error("traceparent does not carry the client span")
+	}
+}
+
+func TestTraceContinuesIncomingTraceparent(t *testing.T) {
+	const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	r := startServerSpan(httptest.NewRequest(http.MethodGet, "/", nil))
+	if SpanFromContext(r.Context()).SpanContext.Sampled {
+		t.Error("new root span is sampled without an exporter")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(traceparentHeader, parent)
+	span := SpanFromContext(startServerSpan(req).Context())
+	if span.SpanContext.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("trace id = %s", span.SpanContext.TraceID)
+	}
+	if span.Parent.String() != "00f067aa0ba902b7" || !span.SpanContext.Sampled {
+		t.Errorf("span does not continue %s", parent)
+	}
+}
@@ -0,0 +1,134 @@
+package microbot
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// TransportOptions defines the options for Transport.
+type TransportOptions struct {
+	// Route returns the route name of an outgoing request, which is used as
+	// label instead of its path to keep the cardinality low.
+	// Optional. Default value returns an empty route.
+	Route func(r *http.Request) string
+}
+
+type transport struct {
+	base http.RoundTripper
+	opts TransportOptions
+}
+
+// Transport returns a http.RoundTripper which records metrics of outgoing
+// requests by target host and route. http.DefaultTransport is used if base is
+// nil.
+func Transport(base http.RoundTripper, opts TransportOptions) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{base: base, opts: opts}
+}
+
+func (t *transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	host := r.URL.Host
+	var route string
+	if t.opts.Route != nil {
+		route = t.opts.Route(r)
+	}
+
+	if SpanFromContext(r.Context()) != nil {
+		name := r.Method
+		if route != "" {
+			name += " " + route
+		}
+		ctx, span := StartSpan(r.Context(), name, SpanKindClient)
+		span.SetAttribute("http.request.method", r.Method)
+		span.SetAttribute("server.address", host)
+		r = r.Clone(ctx)
+		injectSpan(r.Header, span)
+		defer span.Finish()
+	}
+
+	var (
+		mu                               sync.Mutex
+		dnsStart, connectStart, tlsStart time.Time
+	)
+	start := func(t *time.Time) {
+		mu.Lock()
+		*t = time.Now()
+		mu.Unlock()
+	}
+	done := func(phase string, t *time.Time) {
+		mu.Lock()
+		begun := *t
+		mu.Unlock()
+		if !begun.IsZero() {
+			d := time.Since(begun).Nanoseconds() / int64(time.Millisecond)
+			clientPhaseDuration.WithLabelValues(host, phase).Observe(float64(d))
+		}
+	}
+	trace := &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { start(&dnsStart) },
+		DNSDone:           func(httptrace.DNSDoneInfo) { done("dns", &dnsStart) },
+		ConnectStart:      func(string, string) { start(&connectStart) },
+		ConnectDone:       func(string, string, error) { done("connect", &connectStart) },
+		TLSHandshakeStart: func() { start(&tlsStart) },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { done("tls", &tlsStart) },
+	}
+	r = r.WithContext(httptrace.WithClientTrace(r.Context(), trace))
+
+	begun := time.Now()
+	resp, err := t.base.RoundTrip(r)
+	d := time.Since(begun).Nanoseconds() / int64(time.Millisecond)
+	clientDuration.WithLabelValues(host, route, r.Method).Observe(float64(d))
+	span := SpanFromContext(r.Context())
+	if err != nil {
+		if span != nil {
+			span.SetError(err)
+		}
+		clientErrors.WithLabelValues(host, route, transportErrorType(err)).Inc()
+		return resp, err
+	}
+	if span != nil {
+		span.SetAttribute("http.response.status_code", fmt.Sprintf("%d", resp.StatusCode))
+	}
+	clientRequests.WithLabelValues(host, route, r.Method, fmt.Sprintf("%d", resp.StatusCode)).Inc()
+	return resp, nil
+}
+
+// transportErrorType returns the type label of a failed request: canceled,
+// timeout, dns, tls, connection_refused, connection_reset or other.
+func transportErrorType(err error) string {
+	var (
+		dnsErr    *net.DNSError
+		certErr   *tls.CertificateVerificationError
+		recordErr tls.RecordHeaderError
+		alertErr  tls.AlertError
+		netErr    net.Error
+	)
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.As(err, &certErr), errors.As(err, &recordErr), errors.As(err, &alertErr):
+		return "tls"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection_refused"
+	case errors.Is(err, syscall.ECONNRESET):
+		return "connection_reset"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	default:
+		return "other"
+	}
+}
@@ -0,0 +1,120 @@
+package microbot
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func transportRoute(r *http.Request) string { return "/route" }
+
+func TestTransport(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "https://")
+
+	requested := clientRequests.WithLabelValues(host, "/route", http.MethodGet, "202")
+	before := testutil.ToFloat64(requested)
+	durations := histogramCount(t, clientDuration, host, "/route", http.MethodGet)
+	connects := histogramCount(t, clientPhaseDuration, host, "connect")
+	handshakes := histogramCount(t, clientPhaseDuration, host, "tls")
+
+	client := &http.Client{Transport: Transport(srv.Client().Transport, TransportOptions{Route: transportRoute})}
+	resp, err := client.Get(srv.URL + "/items/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if n := testutil.ToFloat64(requested) - before; n != 1 {
+		t.Errorf("requests = %v, want 1", n)
+	}
+	if n := histogramCount(t, clientDuration, host, "/route", http.MethodGet) - durations; n != 1 {
+		t.Errorf("durations = %d, want 1", n)
+	}
+	if n := histogramCount(t, clientPhaseDuration, host, "connect") - connects; n != 1 {
+		t.Errorf("connect phases = %d, want 1", n)
+	}
+	if n := histogramCount(t, clientPhaseDuration, host, "tls") - handshakes; n != 1 {
+		t.Errorf("tls phases = %d, want 1", n)
+	}
+}
+
+func TestTransportErrors(t *testing.T) {
+	// A port nothing listens on.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refused := ln.Addr().String()
+	ln.Close()
+
+	// A server whose certificate the client does not trust.
+	untrusted := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	untrusted.Config.ErrorLog = log.New(io.Discard, "", 0)
+	untrusted.StartTLS()
+	defer untrusted.Close()
+
+	release := make(chan struct{})
+	defer close(release)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer slow.Close()
+
+	tests := []struct {
+		name string
+		url  string
+		ctx  func() (context.Context, context.CancelFunc)
+		want string
+	}{
+		{"connection refused", "http://" + refused, nil, "connection_refused"},
+		{"tls", untrusted.URL, nil, "tls"},
+		{"timeout", slow.URL, func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 50*time.Millisecond)
+		}, "timeout"},
+		{"canceled", slow.URL, func() (context.Context, context.CancelFunc) {
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(50*time.Millisecond, cancel)
+			return ctx, cancel
+		}, "canceled"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.Background(), context.CancelFunc(func() {})
+			if tt.ctx != nil {
+				ctx, cancel = tt.ctx()
+			}
+			defer cancel()
+			r, err := http.NewRequestWithContext(ctx, http.MethodGet, tt.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			errs := clientErrors.WithLabelValues(r.URL.Host, "/route", tt.want)
+			before := testutil.ToFloat64(errs)
+
+			// A transport of its own, so that no connection is reused.
+			rt := Transport(&http.Transport{}, TransportOptions{Route: transportRoute})
+			if resp, err := rt.RoundTrip(r); err == nil {
+				resp.Body.Close()
+				t.Fatal("request succeeded")
+			}
+			if n := testutil.ToFloat64(errs) - before; n != 1 {
+				t.Errorf("errors of type %s = %v, want 1", tt.want, n)
+			}
+		})
+	}
+}
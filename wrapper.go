@@ -1,16 +1,26 @@
 package microbot
 
 import (
+	"bufio"
+	"errors"
+	"net"
 	"net/http"
+	"time"
 )
 
 type StatusWriter struct {
 	http.ResponseWriter
 	status int
 	length int
+
+	begun     time.Time
+	firstByte time.Duration
+	wrote     bool
+	streaming bool
 }
 
 func (w *StatusWriter) WriteHeader(status int) {
+	w.markFirstByte()
 	w.status = status
 	w.ResponseWriter.WriteHeader(status)
 }
@@ -19,7 +29,71 @@ func (w *StatusWriter) Write(b []byte) (int, error) {
 	if w.status == 0 {
 		w.status = 200
 	}
+	w.markFirstByte()
 	n, err := w.ResponseWriter.Write(b)
 	w.length += n
+	if w.streaming {
+		streamedBytes.Add(float64(n))
+	}
 	return n, err
 }
+
+// Flush implements http.Flusher. The first call marks the response as a
+// stream, so that it is counted in open streams until the handler returns.
+func (w *StatusWriter) Flush() {
+	if !w.streaming {
+		w.streaming = true
+		openStreams.Inc()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker when the underlying writer does, so that
+// WebSocket upgrades work behind the middlewares.
+func (w *StatusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("microbot: response does not implement http.Hijacker")
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil && w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// CloseNotify implements http.CloseNotifier, which echo asserts without
+// checking. The channel never fires if the underlying writer has none.
+func (w *StatusWriter) CloseNotify() <-chan bool {
+	if cn, ok := w.ResponseWriter.(http.CloseNotifier); ok {
+		return cn.CloseNotify()
+	}
+	return make(chan bool)
+}
+
+// Unwrap returns the underlying writer, for http.ResponseController.
+func (w *StatusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *StatusWriter) markFirstByte() {
+	if w.wrote {
+		return
+	}
+	w.wrote = true
+	if !w.begun.IsZero() {
+		w.firstByte = time.Since(w.begun)
+	}
+}
+
+// finish records time to first byte and closes the stream if any.
+func (w *StatusWriter) finish(path, method string) {
+	if w.streaming {
+		openStreams.Dec()
+	}
+	if w.wrote && !w.begun.IsZero() {
+		ttfb.WithLabelValues(path, method).Observe(float64(w.firstByte.Nanoseconds() / int64(time.Millisecond)))
+	}
+}
@@ -0,0 +1,91 @@
+package microbot
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo"
+)
+
+func TestStatusWriterRecordsStatusAndLength(t *testing.T) {
+	rec := httptest.NewRecorder()
+	sw := &StatusWriter{ResponseWriter: rec}
+	sw.WriteHeader(http.StatusCreated)
+	sw.Write([]byte("hello"))
+	if sw.status != http.StatusCreated {
+		t.Errorf("status = %d, want %d", sw.status, http.StatusCreated)
+	}
+	if sw.length != 5 {
+		t.Errorf("length = %d, want 5", sw.length)
+	}
+	if sw.Unwrap() != rec {
+		t.Error("Unwrap does not return the underlying writer")
+	}
+}
+
+func TestStatusWriterHijackUnsupported(t *testing.T) {
+	sw := &StatusWriter{ResponseWriter: httptest.NewRecorder()}
+	if _, _, err := sw.Hijack(); err == nil {
+		t.Error("Hijack succeeded on a writer which is no http.Hijacker")
+	}
+}
+
+var upgrader = websocket.Upgrader{}
+
+// echoWebSocket upgrades the request and echoes one message.
+func echoWebSocket(w http.ResponseWriter, r *http.Request) error {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	mt, msg, err := conn.ReadMessage()
+	if err != nil {
+		return err
+	}
+	return conn.WriteMessage(mt, msg)
+}
+
+func dialEcho(t *testing.T, url string) {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(url, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("ping")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_, msg, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(msg) != "ping" {
+		t.Errorf("message = %q, want ping", msg)
+	}
+}
+
+func TestMiddlewareWebSocket(t *testing.T) {
+	h := Middleware(nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := echoWebSocket(w, r); err != nil {
+			t.Error(err)
+		}
+	}))
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	dialEcho(t, srv.URL)
+}
+
+func TestMiddlewareEchoWebSocket(t *testing.T) {
+	e := echo.New()
+	e.Use(MiddlewareEcho())
+	e.GET("/ws", func(c echo.Context) error {
+		return echoWebSocket(c.Response(), c.Request())
+	})
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+	dialEcho(t, srv.URL+"/ws")
+}